@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,113 +19,352 @@ import (
 )
 
 // processAdmissionRequest processes an admission request and returns an admission response
-func (s *Server) processAdmissionRequest(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+func (s *Server) processAdmissionRequest(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	start := time.Now()
+	response, result := s.admit(ctx, req)
+
+	decision := decisionFor(response, result.reason)
+	recordAdmission(req.Operation, decision, result.reason)
+	s.recordEvent(req, response, decision, &result)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String(attributeOperation, string(req.Operation)),
+		attribute.String(attributeNamespace, req.Namespace),
+		attribute.String(attributeDecision, decision),
+		attribute.String(attributeReason, result.reason),
+		attribute.String(attributeProfile, result.profile),
+		attribute.Int(attributePatchBytes, len(response.Patch)),
+	)
+	if s.decisions != nil {
+		s.decisions.Record(newDecisionRecord(req, response, decision, &result, time.Since(start)))
+	}
+
+	return response
+}
+
+// decisionFor returns the decision an admission response and reason code amount to, as
+// skipped pods may still be patched to record the decision
+func decisionFor(response *admissionv1.AdmissionResponse, reason string) string {
+	switch {
+	case !response.Allowed:
+		return DecisionDenied
+	case reason == ReasonInjected || reason == ReasonReconciled:
+		return DecisionInjected
+	default:
+		return DecisionSkipped
+	}
+}
+
+// admissionResult explains an admission decision
+type admissionResult struct {
+	// reason is the reason code of the decision
+	reason string
+	// profile is the DNS profile of an injected pod
+	profile string
+	// pod is the metadata of the pod, nil if it was not decoded
+	pod *podMetadata
+	// truncated is the warning about the search domains dropped beyond the search path limits
+	truncated string
+}
+
+// admit decides on an admission request and returns the response along with what led to it
+func (s *Server) admit(ctx context.Context, req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, admissionResult) {
 	// Create base response with request UID
 	response := &admissionv1.AdmissionResponse{
 		UID:     req.UID,
 		Allowed: true,
 	}
 
+	// The policy span covers everything up to the profile, ending it again is a no-op
+	_, policySpan := s.tracer.Start(ctx, SpanResolvePolicy)
+	defer policySpan.End()
+
+	cfg := s.Config()
+	if cfg == nil {
+		s.logger.V(3).Info("Skipping admission request before configuration is loaded")
+		return response, admissionResult{reason: ReasonConfigNotLoaded}
+	}
+
 	// Only process Pod resources
 	if req.Kind.Kind != "Pod" || req.Resource.Resource != "pods" {
 		s.logger.V(3).Info("Skipping non-pod resource",
 			"kind", req.Kind.Kind,
 			"resource", req.Resource.Resource,
 		)
-		return response
+		return response, admissionResult{reason: ReasonNotPod}
+	}
+
+	switch req.Operation {
+	case admissionv1.Create: // for create, we need to inject dnsConfig
+	case admissionv1.Update:
+		// DNSPolicy and DNSConfig are immutable, so an update never needs a patch
+		return response, admissionResult{reason: ReasonUpdateNoop}
+	default:
+		s.logger.V(3).Info("Skipping non-create/update operation", "operation", string(req.Operation))
+		return response, admissionResult{reason: ReasonOperationIgnored}
 	}
 
-	var pod corev1.Pod
+	var pod podInfo
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
 		s.logger.Error(err, "Failed to unmarshal pod from request")
-		return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to parse pod: %v", err))
+		recordError(ErrorClassDecode)
+		endSpan(policySpan, err)
+		return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to parse pod: %v", err)), admissionResult{reason: ReasonDecodeError}
 	}
-	podCopy := pod.DeepCopy()
-	switch req.Operation {
-	case admissionv1.Create: // for create, we need to inject dnsConfig
-		domains := []string{
-			fmt.Sprintf("%s.svc.%s", pod.Namespace, s.config.ClusterDomain),
-			fmt.Sprintf("svc.%s", s.config.ClusterDomain),
-			s.config.ClusterDomain,
-		}
-		dnsConfig := &DNSConfig{
-			Nameservers: []string{s.config.NodeLocalDNSAddress, s.config.ClusterDNSAddress},
-			Searches:    domains,
-			Options:     s.config.DNSOptions,
-		}
-		if err := injectDNSConfig(podCopy, dnsConfig); err != nil {
-			s.logger.Error(err, "DNS injection failed",
-				"Name", pod.Name,
-				"Namespace", pod.Namespace,
-			)
 
-			return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to inject DNS configuration: %v", err))
+	// Pods we injected before carry our DNS settings, which are reconciled rather than skipped.
+	// Copies of injected pods, such as rendered templates, whose settings were changed since
+	// are treated like any other pod.
+	injected, reinvoked := pod.Metadata.Annotations[ConfigHashAnnotation]
+	reinvoked = reinvoked && pod.Spec.writtenWith(injected)
+	if !reinvoked {
+		if reason := skipReason(&pod.Spec); reason != "" {
+			s.logger.V(3).Info("Skipping DNS injection",
+				"Name", pod.Metadata.Name,
+				"Namespace", pod.Metadata.Namespace,
+				"reason", reason,
+			)
+			policySpan.End()
+			return s.recordProvenance(ctx, req, cfg, response, nil, DecisionSkipped, admissionResult{reason: reason, pod: &pod.Metadata}, "")
 		}
-	case admissionv1.Update: // for update, we need to reset the dnsConfig
-		var oldPod corev1.Pod
-		if err := json.Unmarshal(req.OldObject.Raw, &oldPod); err != nil {
-			s.logger.Error(err, "Failed to unmarshal old pod from update request")
-			return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to parse pod: %v", err))
+	}
+
+	// Pods being created often leave their namespace to the request
+	namespace := pod.Metadata.Namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+	ns := s.lookupNamespace(namespace)
+
+	profile, err := resolveProfile(cfg, &pod.Metadata, ns)
+	if err != nil {
+		s.logger.V(3).Info("Denying pod selecting an unknown DNS profile",
+			"Name", pod.Metadata.Name,
+			"Namespace", namespace,
+			"error", err.Error(),
+		)
+		endSpan(policySpan, err)
+		return s.createErrorResponse(string(req.UID), err.Error()), admissionResult{reason: ReasonUnknownProfile, pod: &pod.Metadata}
+	}
+	policySpan.End()
+
+	vars := &searchVars{
+		Namespace:     namespace,
+		ClusterDomain: cfg.ClusterDomain,
+		PodLabels:     pod.Metadata.Labels,
+	}
+	if ns != nil {
+		vars.NamespaceLabels = ns.Labels
+	}
+	_, injectSpan := s.tracer.Start(ctx, SpanInject)
+	patch, err := s.patches.get(cfg, profile, vars)
+	endSpan(injectSpan, err)
+	if err != nil {
+		s.logger.Error(err, "Failed to generate patch",
+			"Name", pod.Metadata.Name,
+			"Namespace", pod.Metadata.Namespace,
+		)
+		recordError(ErrorClassPatch)
+		return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to generate patch: %v", err)), admissionResult{reason: ReasonPatchError, pod: &pod.Metadata, profile: profile}
+	}
+
+	reason := ReasonInjected
+	if reinvoked {
+		if injected == patch.hash && patch.matches(&pod.Spec) {
+			s.logger.V(3).Info("Pod already injected",
+				"Name", pod.Metadata.Name,
+				"Namespace", namespace,
+			)
+			return response, admissionResult{reason: ReasonAlreadyInjected, pod: &pod.Metadata, profile: profile}
 		}
-		// DNSPolicy and DNSConfig is immutable, need to reset to the same as existing pod
-		// The generated patch will have no effect
-		podCopy.Spec.DNSConfig = oldPod.Spec.DNSConfig
-		podCopy.Spec.DNSPolicy = oldPod.Spec.DNSPolicy
-	default:
-		s.logger.V(3).Info("Skipping non-create/update operation", "operation", string(req.Operation))
-		return response
+		// The configuration or the labels rendered into the search domains changed since
+		reason = ReasonReconciled
+	}
+
+	for _, warning := range patch.warnings {
+		s.logger.V(2).Info("Search domain dropped",
+			"Name", pod.Metadata.Name,
+			"Namespace", namespace,
+			"warning", warning,
+		)
 	}
-	patch, err := s.generateJSONPatch(&pod, podCopy)
+
+	response.Warnings = patch.warnings
+
+	s.logger.V(3).Info("DNS injection successful",
+		"Name", pod.Metadata.Name,
+		"Namespace", namespace,
+		"profile", profile,
+		"reason", reason,
+	)
+	return s.recordProvenance(ctx, req, cfg, response, patch.patch, DecisionInjected,
+		admissionResult{reason: reason, pod: &pod.Metadata, profile: profile, truncated: patch.truncated}, patch.hash)
+}
+
+// recordProvenance sets the patch in the response, along with the operations recording
+// the decision on the pod
+func (s *Server) recordProvenance(ctx context.Context, req *admissionv1.AdmissionRequest, cfg *Config, response *admissionv1.AdmissionResponse,
+	patch []byte, decision string, result admissionResult, hash string) (*admissionv1.AdmissionResponse, admissionResult) {
+	_, span := s.tracer.Start(ctx, SpanGeneratePatch)
+	patch, err := appendJSONPatch(patch, provenancePatch(cfg, result.pod, decision, result.reason, result.profile, hash))
+	endSpan(span, err)
 	if err != nil {
 		s.logger.Error(err, "Failed to generate patch",
-			"Name", pod.Name,
-			"Namespace", pod.Namespace,
+			"Name", result.pod.Name,
+			"Namespace", req.Namespace,
 		)
-		return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to generate patch: %v", err))
+		recordError(ErrorClassPatch)
+		result.reason = ReasonPatchError
+		return s.createErrorResponse(string(req.UID), fmt.Sprintf("Failed to generate patch: %v", err)), result
+	}
+	if patch == nil {
+		return response, result
 	}
 
 	// Set patch in response
 	patchType := admissionv1.PatchTypeJSONPatch
 	response.Patch = patch
 	response.PatchType = &patchType
+	return response, result
+}
 
-	s.logger.V(3).Info("DNS injection successful",
-		"Name", pod.Name,
-		"Namespace", pod.Namespace,
-	)
-	return response
+// podInfo holds the fields of a pod the injection decision needs, so that the
+// rest of the pod, such as its containers, is skipped when decoding
+type podInfo struct {
+	Metadata podMetadata `json:"metadata"`
+	Spec     podDNSSpec  `json:"spec"`
 }
 
-// generateJSONPatch generates a JSON patch between original and modified pods
-func (s *Server) generateJSONPatch(original, modified *corev1.Pod) ([]byte, error) {
-	// Create JSON patch operations
-	var patches []map[string]interface{}
+// podMetadata holds the metadata of a pod the injection decision needs
+type podMetadata struct {
+	Name            string                  `json:"name,omitempty"`
+	GenerateName    string                  `json:"generateName,omitempty"`
+	Namespace       string                  `json:"namespace,omitempty"`
+	Labels          map[string]string       `json:"labels,omitempty"`
+	Annotations     map[string]string       `json:"annotations,omitempty"`
+	OwnerReferences []metav1.OwnerReference `json:"ownerReferences,omitempty"`
+}
 
-	// Add DNS policy change
-	patches = append(patches, map[string]interface{}{
-		"op":    "replace",
-		"path":  "/spec/dnsPolicy",
-		"value": string(corev1.DNSNone),
-	})
+// owner returns the controller of the pod, falling back to its first owner, nil if it has none
+func (m *podMetadata) owner() *metav1.OwnerReference {
+	var owner *metav1.OwnerReference
+	for i := range m.OwnerReferences {
+		if i == 0 || (m.OwnerReferences[i].Controller != nil && *m.OwnerReferences[i].Controller) {
+			owner = &m.OwnerReferences[i]
+		}
+	}
+	return owner
+}
+
+// podDNSSpec holds the DNS related fields of a pod spec
+type podDNSSpec struct {
+	DNSPolicy   corev1.DNSPolicy     `json:"dnsPolicy,omitempty"`
+	DNSConfig   *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+	HostNetwork bool                 `json:"hostNetwork,omitempty"`
+}
+
+// profileDNSConfig returns the DNS configuration of pods selecting the profile, whose
+// settings override the base configuration, with the search domains as templates. Only
+// the fields the injection strategy adds are set.
+func profileDNSConfig(cfg *Config, profile string) *DNSConfig {
+	dnsConfig := &DNSConfig{
+		Nameservers: cfg.Nameservers,
+		Searches:    cfg.Searches,
+		Options:     cfg.DNSOptions,
+		Strategy:    cfg.Strategy,
+	}
 
-	// Add DNS configuration
-	if modified.Spec.DNSConfig != nil {
-		patches = append(patches, map[string]interface{}{
-			"op":    "add",
-			"path":  "/spec/dnsConfig",
-			"value": modified.Spec.DNSConfig,
-		})
+	if override, ok := cfg.Profiles[profile]; ok {
+		if len(override.Nameservers) > 0 {
+			dnsConfig.Nameservers = override.Nameservers
+		}
+		if len(override.Searches) > 0 {
+			dnsConfig.Searches = override.Searches
+		}
+		if override.Options != nil {
+			dnsConfig.Options = override.Options
+		}
+		if override.Strategy != "" {
+			dnsConfig.Strategy = override.Strategy
+		}
 	}
 
-	// Marshal patches to JSON
+	switch dnsConfig.Strategy {
+	case StrategyOptionsOnly:
+		// Kubelet merges the options into those of the pod's dnsPolicy
+		dnsConfig.Nameservers = nil
+		dnsConfig.Searches = nil
+	case StrategyPrependNameserver:
+		// Kubelet appends the nameservers to those of the pod's dnsPolicy, keeping its search path
+		dnsConfig.Nameservers = []string{cfg.NodeLocalDNSAddress}
+		dnsConfig.Searches = nil
+		dnsConfig.Options = nil
+	default:
+		dnsConfig.Nameservers = resolveNameservers(cfg, dnsConfig.Nameservers)
+		dnsConfig.Searches = withClusterDomains(cfg, dnsConfig.Searches)
+	}
+	return dnsConfig
+}
+
+// resolveNameservers resolves the nameserver sources to addresses, omitting duplicates
+// such as a static IP equal to the discovered cluster DNS
+func resolveNameservers(cfg *Config, sources []string) []string {
+	nameservers := make([]string, 0, len(sources))
+	for _, source := range sources {
+		address := source
+		switch source {
+		case NameserverNodeLocal:
+			address = cfg.NodeLocalDNSAddress
+		case NameserverClusterDNS:
+			address = cfg.ClusterDNSAddress
+		}
+		if !slices.Contains(nameservers, address) {
+			nameservers = append(nameservers, address)
+		}
+	}
+	return nameservers
+}
+
+// generateJSONPatch generates the JSON patch injecting the DNS configuration into a pod
+// with its strategy
+func generateJSONPatch(dnsConfig *DNSConfig) ([]byte, error) {
+	var patches []jsonPatchOperation
+	if dnsConfig.replacesPolicy() {
+		patches = append(patches, jsonPatchOperation{Op: "replace", Path: "/spec/dnsPolicy", Value: corev1.DNSNone})
+	}
+	// Adding an existing member replaces it, which reconciles a reinvoked pod
+	patches = append(patches, jsonPatchOperation{Op: "add", Path: "/spec/dnsConfig", Value: dnsConfig.podDNSConfig()})
+
 	patchBytes, err := json.Marshal(patches)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JSON patch: %w", err)
 	}
+	return patchBytes, nil
+}
+
+// dnsSettingsHash returns a short hash identifying the DNS settings an injection writes,
+// whether it sets dnsPolicy None and the dnsConfig
+func dnsSettingsHash(replacesPolicy bool, dnsConfig *corev1.PodDNSConfig) (string, error) {
+	data, err := json.Marshal(struct {
+		ReplacesPolicy bool                 `json:"replacesPolicy"`
+		DNSConfig      *corev1.PodDNSConfig `json:"dnsConfig"`
+	}{replacesPolicy, dnsConfig})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal DNS settings: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
 
-	s.logger.V(3).Info("Generated JSON patch", "patchOperations", len(patches))
+// escapeJSONPointer escapes a JSON pointer reference token
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
 
-	return patchBytes, nil
+// jsonPatchOperation is a single JSON patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
 }
 
 // createErrorResponse creates an admission response that denies the request with an error
@@ -132,53 +379,65 @@ func (s *Server) createErrorResponse(uid string, message string) *admissionv1.Ad
 	}
 }
 
-func injectDNSConfig(pod *corev1.Pod, dnsConfig *DNSConfig) error {
-	if pod == nil || pod.Spec.DNSConfig != nil {
-		// Skip injection if pod is nil or already has DNS configuration
-		return nil
+// writtenWith reports whether the DNS settings are those an injection recorded with the
+// hash wrote
+func (s *podDNSSpec) writtenWith(hash string) bool {
+	if s.DNSConfig == nil {
+		return false
 	}
+	written, err := dnsSettingsHash(s.DNSPolicy == corev1.DNSNone, s.DNSConfig)
+	return err == nil && written == hash
+}
 
+// skipReason returns the reason code for which a pod must not be injected, or an empty string if it is eligible
+func skipReason(spec *podDNSSpec) string {
+	// Skip injection if pod already has DNS configuration
+	if spec.DNSConfig != nil {
+		return ReasonExistingDNSConfig
+	}
 	// Skip injection if dnsPolicy is explicitly set to None
-	if pod.Spec.DNSPolicy == corev1.DNSNone {
-		return nil
+	if spec.DNSPolicy == corev1.DNSNone {
+		return ReasonDNSPolicyNone
+	}
+	// Skip injection if the pod resolves with the node's resolv.conf rather than the cluster DNS
+	if spec.DNSPolicy == corev1.DNSDefault {
+		return ReasonDNSPolicyDefault
 	}
 	// Skip injection if hostnetwork but without DNSClusterFirstWithHostNet policy
-	if pod.Spec.HostNetwork && pod.Spec.DNSPolicy != corev1.DNSClusterFirstWithHostNet {
-		return nil
+	if spec.HostNetwork && spec.DNSPolicy != corev1.DNSClusterFirstWithHostNet {
+		return ReasonHostNetwork
 	}
-	// Create a copy of the pod to avoid modifying the original
-	podCopy := pod.DeepCopy()
+	return ""
+}
 
-	// Set DNS policy to None to use custom DNS configuration
-	podCopy.Spec.DNSPolicy = corev1.DNSNone
+// replacesPolicy reports whether the strategy replaces the pod's dnsPolicy with None
+func (c *DNSConfig) replacesPolicy() bool {
+	return c.Strategy != StrategyOptionsOnly && c.Strategy != StrategyPrependNameserver
+}
 
-	// Create DNS configuration for Kubernetes
+// podDNSConfig converts the DNS configuration to its Kubernetes representation
+func (c *DNSConfig) podDNSConfig() *corev1.PodDNSConfig {
 	podDNSConfig := &corev1.PodDNSConfig{
-		Nameservers: make([]string, len(dnsConfig.Nameservers)),
-		Searches:    make([]string, len(dnsConfig.Searches)),
-		Options:     make([]corev1.PodDNSConfigOption, len(dnsConfig.Options)),
+		Nameservers: make([]string, len(c.Nameservers)),
+		Searches:    make([]string, len(c.Searches)),
+		Options:     make([]corev1.PodDNSConfigOption, len(c.Options)),
 	}
 
 	// Copy nameservers
-	copy(podDNSConfig.Nameservers, dnsConfig.Nameservers)
+	copy(podDNSConfig.Nameservers, c.Nameservers)
 
 	// Copy search domains
-	copy(podDNSConfig.Searches, dnsConfig.Searches)
+	copy(podDNSConfig.Searches, c.Searches)
 
 	// Copy DNS options - convert from config.DNSOption to corev1.PodDNSConfigOption
-	for i, opt := range dnsConfig.Options {
-		value := opt.Value // Create a copy to avoid pointer issues
-		podDNSConfig.Options[i] = corev1.PodDNSConfigOption{
-			Name:  opt.Name,
-			Value: &value,
+	for i, opt := range c.Options {
+		podDNSConfig.Options[i] = corev1.PodDNSConfigOption{Name: opt.Name}
+		// Flags such as single-request-reopen have no value
+		if opt.Value != "" {
+			value := opt.Value // Create a copy to avoid pointer issues
+			podDNSConfig.Options[i].Value = &value
 		}
 	}
 
-	// Assign the DNS configuration to the pod
-	podCopy.Spec.DNSConfig = podDNSConfig
-
-	// Copy the modified pod back to the original
-	*pod = *podCopy
-
-	return nil
+	return podDNSConfig
 }
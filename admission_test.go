@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// benchmarkPod returns a pod of typical size, with several containers carrying
+// environment, volume mounts and resources that the injection does not need
+func benchmarkPod() *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "web-7d4b9c8f5-",
+			Namespace:    "shop",
+			Labels:       map[string]string{"app": "web", "pod-template-hash": "7d4b9c8f5"},
+			Annotations:  map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "9090"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       "web-7d4b9c8f5",
+				UID:        "6b1a1f6e-55d8-4a8e-9c0c-8d7f1f2a3b4c",
+			}},
+		},
+		Spec: corev1.PodSpec{
+			DNSPolicy:          corev1.DNSClusterFirst,
+			ServiceAccountName: "web",
+		},
+	}
+	for i := 0; i < 3; i++ {
+		container := corev1.Container{
+			Name:  fmt.Sprintf("container-%d", i),
+			Image: "registry.example.com/shop/web:1.2.3",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+			},
+		}
+		for j := 0; j < 20; j++ {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  fmt.Sprintf("ENV_%d", j),
+				Value: fmt.Sprintf("value-%d", j),
+			})
+		}
+		for j := 0; j < 5; j++ {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      fmt.Sprintf("volume-%d", j),
+				MountPath: fmt.Sprintf("/mnt/volume-%d", j),
+			})
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+	}
+	return pod
+}
+
+// benchmarkRequest returns a CREATE admission request for the benchmark pod
+func benchmarkRequest(tb testing.TB) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(benchmarkPod())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: "shop",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// legacyProcessAdmissionRequest is the previous implementation of the injection path,
+// which decodes the whole pod, deep copies it and marshals a generic patch, kept to
+// compare against in benchmarks
+func legacyProcessAdmissionRequest(cfg *Config, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return response
+	}
+	spec := podDNSSpec{DNSPolicy: pod.Spec.DNSPolicy, DNSConfig: pod.Spec.DNSConfig, HostNetwork: pod.Spec.HostNetwork}
+	if skipReason(&spec) != "" {
+		return response
+	}
+	podCopy := pod.DeepCopy()
+	dnsConfig := &DNSConfig{
+		Nameservers: []string{cfg.NodeLocalDNSAddress, cfg.ClusterDNSAddress},
+		Searches: []string{
+			fmt.Sprintf("%s.svc.%s", pod.Namespace, cfg.ClusterDomain),
+			fmt.Sprintf("svc.%s", cfg.ClusterDomain),
+			cfg.ClusterDomain,
+		},
+		Options: cfg.DNSOptions,
+	}
+	podCopy.Spec.DNSPolicy = corev1.DNSNone
+	podCopy.Spec.DNSConfig = dnsConfig.podDNSConfig()
+
+	patches := []map[string]interface{}{
+		{"op": "replace", "path": "/spec/dnsPolicy", "value": string(corev1.DNSNone)},
+		{"op": "add", "path": "/spec/dnsConfig", "value": podCopy.Spec.DNSConfig},
+	}
+	patch, err := json.Marshal(patches)
+	if err != nil {
+		return response
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = patch
+	response.PatchType = &patchType
+	return response
+}
+
+// dnsPatch returns the operations of a JSON patch that set the DNS settings, leaving
+// out those recording the provenance
+func dnsPatch(t *testing.T, patch []byte) string {
+	var operations []json.RawMessage
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		t.Fatalf("patch %s is not a JSON patch: %v", patch, err)
+	}
+	var kept []string
+	for _, operation := range operations {
+		if !bytes.Contains(operation, []byte(`"/metadata/`)) {
+			kept = append(kept, string(operation))
+		}
+	}
+	return "[" + strings.Join(kept, ",") + "]"
+}
+
+func TestProcessAdmissionRequestMatchesLegacy(t *testing.T) {
+	s := newTestServer()
+	req := benchmarkRequest(t)
+
+	got := s.processAdmissionRequest(context.Background(), req)
+	want := legacyProcessAdmissionRequest(s.Config(), req)
+	if dnsPatch(t, got.Patch) != string(want.Patch) {
+		t.Errorf("patch = %s, want %s", got.Patch, want.Patch)
+	}
+
+	// A cached patch is identical to a freshly built one
+	if cached := s.processAdmissionRequest(context.Background(), req); string(cached.Patch) != string(got.Patch) {
+		t.Errorf("cached patch = %s, want %s", cached.Patch, got.Patch)
+	}
+}
+
+func BenchmarkProcessAdmissionRequest(b *testing.B) {
+	s := newTestServer()
+	req := benchmarkRequest(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.processAdmissionRequest(context.Background(), req)
+	}
+}
+
+func BenchmarkLegacyProcessAdmissionRequest(b *testing.B) {
+	cfg := DefaultConfig()
+	req := benchmarkRequest(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		legacyProcessAdmissionRequest(cfg, req)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "batch-jobs",
+		Labels: map[string]string{"node-local-dns-injection": "batch"},
+	}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "typo",
+		Annotations: map[string]string{ProfileAnnotation: "bacth"},
+	}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "legacy",
+		Labels: map[string]string{"node-local-dns-injection": "true"},
+	}})
+
+	s := newTestServer()
+	s.namespaces = corelisters.NewNamespaceLister(indexer)
+	cfg := DefaultConfig()
+	cfg.Profiles = map[string]DNSConfig{
+		"external-heavy":   {Options: []DNSOption{{Name: "ndots", Value: "1"}}},
+		"latency-critical": {Options: []DNSOption{{Name: "timeout", Value: "1"}, {Name: "attempts", Value: "1"}, {Name: "single-request-reopen"}}},
+		"batch":            {Options: []DNSOption{{Name: "ndots", Value: "2"}}},
+	}
+	s.SetConfig(cfg)
+
+	tests := []struct {
+		name        string
+		namespace   string
+		labels      map[string]string
+		annotations map[string]string
+		wantDenied  bool
+		wantOptions []DNSOption
+	}{
+		{name: "default", namespace: "shop", wantOptions: cfg.DNSOptions},
+		{name: "enabled label", namespace: "shop", labels: map[string]string{"node-local-dns-injection": "enabled"}, wantOptions: cfg.DNSOptions},
+		{name: "pod label", namespace: "shop", labels: map[string]string{"node-local-dns-injection": "external-heavy"}, wantOptions: cfg.Profiles["external-heavy"].Options},
+		{name: "pod annotation", namespace: "shop", annotations: map[string]string{ProfileAnnotation: "latency-critical"}, wantOptions: cfg.Profiles["latency-critical"].Options},
+		{name: "annotation over label", namespace: "shop", labels: map[string]string{"node-local-dns-injection": "batch"}, annotations: map[string]string{ProfileAnnotation: "external-heavy"}, wantOptions: cfg.Profiles["external-heavy"].Options},
+		{name: "namespace label", namespace: "batch-jobs", wantOptions: cfg.Profiles["batch"].Options},
+		{name: "pod over namespace", namespace: "batch-jobs", annotations: map[string]string{ProfileAnnotation: "default"}, wantOptions: cfg.DNSOptions},
+		{name: "unknown pod profile", namespace: "shop", annotations: map[string]string{ProfileAnnotation: "missing"}, wantDenied: true},
+		{name: "unknown namespace profile", namespace: "typo", wantDenied: true},
+		{name: "pod label true", namespace: "shop", labels: map[string]string{"node-local-dns-injection": "true"}, wantOptions: cfg.DNSOptions},
+		{name: "namespace label true", namespace: "legacy", wantOptions: cfg.DNSOptions},
+		{name: "pod label naming no profile", namespace: "shop", labels: map[string]string{"node-local-dns-injection": "off"}, wantDenied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, response, reason := admitPodIn(t, s, tt.namespace, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Labels:      tt.labels,
+				Annotations: tt.annotations,
+			}})
+			if response.Allowed == tt.wantDenied {
+				t.Fatalf("allowed = %v, want %v", response.Allowed, !tt.wantDenied)
+			}
+			if tt.wantDenied {
+				if reason != ReasonUnknownProfile {
+					t.Errorf("reason = %s, want %s", reason, ReasonUnknownProfile)
+				}
+				return
+			}
+
+			want := (&DNSConfig{
+				Nameservers: []string{cfg.NodeLocalDNSAddress, cfg.ClusterDNSAddress},
+				Searches:    []string{tt.namespace + ".svc.cluster.local", "svc.cluster.local", "cluster.local"},
+				Options:     tt.wantOptions,
+			}).podDNSConfig()
+			if !apiequality.Semantic.DeepEqual(patched.Spec.DNSConfig, want) {
+				t.Errorf("dnsConfig = %+v, want %+v", patched.Spec.DNSConfig, want)
+			}
+		})
+	}
+}
+
+func TestSearchTemplates(t *testing.T) {
+	s := newTestServer()
+	cfg := DefaultConfig()
+	cfg.Searches = append(cfg.Searches, `{{index .PodLabels "team"}}.teams.{{.ClusterDomain}}`, `{{index .PodLabels "app"}}`)
+	s.SetConfig(cfg)
+
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		wantSearches []string
+		wantWarnings int
+	}{
+		{
+			name:         "labels set",
+			labels:       map[string]string{"team": "payments", "app": "web"},
+			wantSearches: []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "payments.teams.cluster.local", "web"},
+		},
+		{
+			name:         "label missing",
+			labels:       map[string]string{"app": "web"},
+			wantSearches: []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "web"},
+			wantWarnings: 1,
+		},
+		{
+			name:         "invalid domain",
+			labels:       map[string]string{"team": "payments", "app": "Web_App"},
+			wantSearches: []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "payments.teams.cluster.local"},
+			wantWarnings: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, response, reason := admitPodIn(t, s, "shop", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: tt.labels}})
+			if reason != ReasonInjected {
+				t.Fatalf("reason = %s, want %s", reason, ReasonInjected)
+			}
+			if got := patched.Spec.DNSConfig.Searches; !slices.Equal(got, tt.wantSearches) {
+				t.Errorf("searches = %q, want %q", got, tt.wantSearches)
+			}
+			if len(response.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %q, want %d", response.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+
+	// Templates referring to unknown fields are rejected at load time
+	cfg.Searches = []string{"{{.Cluster}}"}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig accepted an invalid template")
+	}
+}
+
+func TestHostSearches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	resolvConf := "# mirrored from the nodes\nsearch old.example.com\nnameserver 10.0.0.2\nsearch corp.example.com svc.cluster.local eng.example.com. # comment\noptions ndots:1\n"
+	if err := os.WriteFile(path, []byte(resolvConf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hostSearches, err := readResolvConfSearches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"corp.example.com", "svc.cluster.local", "eng.example.com"}; fmt.Sprint(hostSearches) != fmt.Sprint(want) {
+		t.Fatalf("host searches = %q, want %q", hostSearches, want)
+	}
+
+	templates, err := compileSearchTemplates(DefaultSearchTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := &searchVars{Namespace: "shop", ClusterDomain: "cluster.local"}
+
+	// Duplicates of the cluster search domains are omitted
+	searches, warnings, _ := templates.render(vars, hostSearches)
+	want := []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "corp.example.com", "eng.example.com"}
+	if fmt.Sprint(searches) != fmt.Sprint(want) || len(warnings) != 0 {
+		t.Errorf("searches = %q, warnings = %q, want %q", searches, warnings, want)
+	}
+
+	// Domains beyond the search path limits are dropped with a warning
+	for i := 0; i < MaxSearchDomains; i++ {
+		hostSearches = append(hostSearches, fmt.Sprintf("site-%d.example.com", i))
+	}
+	searches, warnings, truncated := templates.render(vars, hostSearches)
+	if len(searches) != MaxSearchDomains || len(warnings) != 1 || warnings[0] != truncated {
+		t.Errorf("got %d searches and warnings %q, want %d searches and a warning", len(searches), warnings, MaxSearchDomains)
+	}
+}
+
+func TestSearchTemplatesLabels(t *testing.T) {
+	tests := []struct {
+		template string
+		want     bool
+	}{
+		{template: "{{.Namespace}}.svc.{{.ClusterDomain}}", want: false},
+		{template: "Labels.{{.ClusterDomain}}", want: false},
+		{template: `{{index .PodLabels "team"}}.{{.ClusterDomain}}`, want: true},
+		{template: "{{.NamespaceLabels.team}}.example.com", want: true},
+		{template: "{{with .PodLabels}}{{.team}}{{end}}.example.com", want: true},
+		{template: "{{if .Namespace}}{{.Namespace}}{{else}}{{$.PodLabels.app}}{{end}}.example.com", want: true},
+		{template: `{{define "team"}}{{.PodLabels.team}}{{end}}{{template "team" .}}.example.com`, want: true},
+	}
+	for _, tt := range tests {
+		templates, err := compileSearchTemplates([]string{tt.template})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.template, err)
+		}
+		if templates.labels != tt.want {
+			t.Errorf("%s: refers to labels = %v, want %v", tt.template, templates.labels, tt.want)
+		}
+	}
+}
+
+func TestAdditionalClusterDomains(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdditionalClusterDomains = parseClusterDomains("clusterset.local,mesh.local:before")
+	if err := validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := compileSearchTemplates(profileDNSConfig(cfg, DefaultProfile).Searches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, _, _ := templates.render(&searchVars{Namespace: "shop", ClusterDomain: cfg.ClusterDomain}, nil)
+	want := []string{
+		"shop.svc.mesh.local", "svc.mesh.local",
+		"shop.svc.cluster.local", "svc.cluster.local", "cluster.local",
+		"shop.svc.clusterset.local", "svc.clusterset.local",
+	}
+	if fmt.Sprint(searches) != fmt.Sprint(want) {
+		t.Errorf("searches = %q, want %q", searches, want)
+	}
+
+	cfg.AdditionalClusterDomains = parseClusterDomains("clusterset.local:middle")
+	if err := validateConfig(cfg); err == nil {
+		t.Error("validateConfig accepted an invalid position")
+	}
+}
+
+func TestNameservers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Nameservers = []string{NameserverClusterDNS, NameserverNodeLocal, "10.0.0.53"}
+	cfg.Profiles = map[string]DNSConfig{
+		"cache-only": {Nameservers: []string{NameserverNodeLocal}},
+		"legacy":     {Nameservers: []string{"10.96.0.10", NameserverClusterDNS}},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile string
+		want    []string
+	}{
+		{profile: DefaultProfile, want: []string{"10.96.0.10", "169.254.20.10", "10.0.0.53"}},
+		{profile: "cache-only", want: []string{"169.254.20.10"}},
+		// A static IP equal to the cluster DNS is only listed once
+		{profile: "legacy", want: []string{"10.96.0.10"}},
+	}
+	for _, tt := range tests {
+		if got := profileDNSConfig(cfg, tt.profile).Nameservers; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: nameservers = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+
+	for _, nameservers := range [][]string{
+		nil,
+		{NameserverNodeLocal, NameserverClusterDNS, "10.0.0.53", "10.0.0.54"},
+		{NameserverNodeLocal, NameserverNodeLocal},
+		{"upstream"},
+	} {
+		cfg.Nameservers = nameservers
+		if err := validateConfig(cfg); err == nil {
+			t.Errorf("validateConfig accepted nameservers %q", nameservers)
+		}
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	cfg := DefaultConfig()
+	tests := []struct {
+		strategy        string
+		wantPatch       string
+		wantPolicy      corev1.DNSPolicy
+		wantNameservers []string
+		wantSearches    []string
+		wantOptions     []string
+	}{
+		{
+			strategy:        StrategyReplace,
+			wantPatch:       `[{"op":"replace","path":"/spec/dnsPolicy","value":"None"},{"op":"add","path":"/spec/dnsConfig","value":{"nameservers":["169.254.20.10","10.96.0.10"],"searches":["shop.custom.example","corp.example.com"],"options":[{"name":"ndots","value":"3"},{"name":"attempts","value":"2"},{"name":"timeout","value":"1"}]}}]`,
+			wantPolicy:      corev1.DNSNone,
+			wantNameservers: []string{"169.254.20.10", "10.96.0.10"},
+			wantSearches:    []string{"shop.custom.example", "corp.example.com"},
+			wantOptions:     []string{"ndots:3", "attempts:2", "timeout:1"},
+		},
+		{
+			strategy:        StrategyOptionsOnly,
+			wantPatch:       `[{"op":"add","path":"/spec/dnsConfig","value":{"options":[{"name":"ndots","value":"3"},{"name":"attempts","value":"2"},{"name":"timeout","value":"1"}]}}]`,
+			wantPolicy:      corev1.DNSClusterFirst,
+			wantNameservers: []string{"10.96.0.10"},
+			wantSearches:    []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "corp.example.com"},
+			wantOptions:     []string{"ndots:3", "attempts:2", "timeout:1"},
+		},
+		{
+			// Kubelet lists the cluster DNS first and keeps its search path and options
+			strategy:        StrategyPrependNameserver,
+			wantPatch:       `[{"op":"add","path":"/spec/dnsConfig","value":{"nameservers":["169.254.20.10"]}}]`,
+			wantPolicy:      corev1.DNSClusterFirst,
+			wantNameservers: []string{"10.96.0.10", "169.254.20.10"},
+			wantSearches:    []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local", "corp.example.com"},
+			wantOptions:     []string{"ndots:5"},
+		},
+	}
+
+	host := &resolvConf{nameservers: []string{"10.0.0.2"}, searches: []string{"corp.example.com"}}
+	cfg.HostSearches = host.searches
+	cfg.Searches = []string{"{{.Namespace}}.custom.example"}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			// Select the strategy through a profile, as set globally it behaves the same
+			cfg.Profiles = map[string]DNSConfig{"keep": {Strategy: tt.strategy}}
+			if err := validateConfig(cfg); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestServer()
+			s.SetConfig(cfg)
+
+			patched, response, reason := admitPodIn(t, s, "shop", &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{ProfileAnnotation: "keep"}},
+				Spec:       corev1.PodSpec{DNSPolicy: corev1.DNSClusterFirst},
+			})
+			if reason != ReasonInjected {
+				t.Fatalf("reason = %s, want %s", reason, ReasonInjected)
+			}
+			if got := dnsPatch(t, response.Patch); got != tt.wantPatch {
+				t.Errorf("patch = %s, want %s", got, tt.wantPatch)
+			}
+			if patched.Spec.DNSPolicy != tt.wantPolicy {
+				t.Errorf("dnsPolicy = %s, want %s", patched.Spec.DNSPolicy, tt.wantPolicy)
+			}
+
+			// What counts is the resolv.conf kubelet generates from the patched pod
+			spec := podDNSSpec{DNSPolicy: patched.Spec.DNSPolicy, DNSConfig: patched.Spec.DNSConfig, HostNetwork: patched.Spec.HostNetwork}
+			conf, violations := kubeletResolvConf(cfg, &spec, "shop", host)
+			if len(violations) > 0 {
+				t.Errorf("violations = %q", violations)
+			}
+			if !slices.Equal(conf.nameservers, tt.wantNameservers) {
+				t.Errorf("resolv.conf nameservers = %q, want %q", conf.nameservers, tt.wantNameservers)
+			}
+			if !slices.Equal(conf.searches, tt.wantSearches) {
+				t.Errorf("resolv.conf searches = %q, want %q", conf.searches, tt.wantSearches)
+			}
+			if !slices.Equal(conf.options, tt.wantOptions) {
+				t.Errorf("resolv.conf options = %q, want %q", conf.options, tt.wantOptions)
+			}
+		})
+	}
+}
+
+// admitPod runs the pod through the webhook and returns the patched pod along with the reason code
+func admitPod(t *testing.T, s *Server, pod *corev1.Pod) (*corev1.Pod, string) {
+	t.Helper()
+	patched, _, reason := admitPodIn(t, s, "shop", pod)
+	return patched, reason
+}
+
+// admitPodIn runs the pod created in the namespace through the webhook and returns the patched
+// pod along with the admission response and the reason code
+func admitPodIn(t *testing.T, s *Server, namespace string, pod *corev1.Pod) (*corev1.Pod, *admissionv1.AdmissionResponse, string) {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+	response, result := s.admit(context.Background(), req)
+	if response.Patch == nil {
+		return pod, response, result.reason
+	}
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("failed to apply patch %s: %v", response.Patch, err)
+	}
+	var patchedPod corev1.Pod
+	if err := json.Unmarshal(patched, &patchedPod); err != nil {
+		t.Fatal(err)
+	}
+	return &patchedPod, response, result.reason
+}
+
+func TestReinvocation(t *testing.T) {
+	s := newTestServer()
+	cfg := s.Config()
+
+	// Pods without annotations are patched differently from those with some
+	for _, annotations := range []map[string]string{nil, {"app": "web"}} {
+		injected, reason := admitPod(t, s, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: annotations}})
+		if reason != ReasonInjected || injected.Annotations[ConfigHashAnnotation] == "" {
+			t.Fatalf("reason = %s, annotations = %v, want an injected pod", reason, injected.Annotations)
+		}
+
+		// Reinvoking the webhook on its own result changes nothing
+		if _, reason := admitPod(t, s, injected); reason != ReasonAlreadyInjected {
+			t.Errorf("reason = %s, want %s", reason, ReasonAlreadyInjected)
+		}
+
+		// Copies carrying the hash whose DNS settings changed since are not overwritten
+		for _, tt := range []struct {
+			name       string
+			mutate     func(spec *corev1.PodSpec)
+			wantReason string
+		}{
+			{
+				name:       "rewritten nameservers",
+				mutate:     func(spec *corev1.PodSpec) { spec.DNSConfig.Nameservers = []string{"10.0.0.53"} },
+				wantReason: ReasonExistingDNSConfig,
+			},
+			{
+				name: "host network",
+				mutate: func(spec *corev1.PodSpec) {
+					spec.HostNetwork = true
+					spec.DNSPolicy = corev1.DNSClusterFirst
+					spec.DNSConfig = nil
+				},
+				wantReason: ReasonHostNetwork,
+			},
+			{
+				name:       "options kept with ClusterFirst",
+				mutate:     func(spec *corev1.PodSpec) { spec.DNSPolicy = corev1.DNSClusterFirst },
+				wantReason: ReasonExistingDNSConfig,
+			},
+		} {
+			copied := injected.DeepCopy()
+			tt.mutate(&copied.Spec)
+			admitted, reason := admitPod(t, s, copied)
+			if reason != tt.wantReason {
+				t.Errorf("%s: reason = %s, want %s", tt.name, reason, tt.wantReason)
+			}
+			if !apiequality.Semantic.DeepEqual(admitted.Spec, copied.Spec) {
+				t.Errorf("%s: spec = %+v, want it unchanged", tt.name, admitted.Spec.DNSConfig)
+			}
+		}
+	}
+
+	// A changed configuration is applied again
+	injected, _ := admitPod(t, s, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+	changed := DefaultConfig()
+	changed.DNSOptions = []DNSOption{{Name: "ndots", Value: "1"}}
+	s.SetConfig(changed)
+	defer s.SetConfig(cfg)
+	if _, reason := admitPod(t, s, injected); reason != ReasonReconciled {
+		t.Errorf("reason = %s, want %s", reason, ReasonReconciled)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	s := newTestServer()
+	cfg := DefaultConfig()
+	cfg.InjectedLabel = "nodelocaldns.io/injected"
+	s.SetConfig(cfg)
+
+	injected, _ := admitPod(t, s, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web",
+		Annotations: map[string]string{"prometheus.io/scrape": "true"},
+	}})
+	want := map[string]string{
+		DecisionAnnotation:        DecisionInjected,
+		ReasonAnnotation:          ReasonInjected,
+		InjectedProfileAnnotation: DefaultProfile,
+		VersionAnnotation:         Version,
+	}
+	for key, value := range want {
+		if got := injected.Annotations[key]; got != value {
+			t.Errorf("annotation %s = %q, want %q", key, got, value)
+		}
+	}
+	if injected.Annotations[ConfigHashAnnotation] == "" {
+		t.Errorf("annotation %s is missing", ConfigHashAnnotation)
+	}
+	if injected.Annotations["prometheus.io/scrape"] != "true" {
+		t.Error("existing annotations were not kept")
+	}
+	if injected.Labels["nodelocaldns.io/injected"] != "true" {
+		t.Errorf("labels = %v, want the injected label", injected.Labels)
+	}
+
+	// Skipped pods record the reason but carry no label or configuration hash
+	skipped, reason := admitPod(t, s, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       corev1.PodSpec{DNSPolicy: corev1.DNSNone},
+	})
+	if skipped.Annotations[DecisionAnnotation] != DecisionSkipped || skipped.Annotations[ReasonAnnotation] != reason {
+		t.Errorf("annotations = %v, want a skipped decision with reason %s", skipped.Annotations, reason)
+	}
+	if _, ok := skipped.Annotations[ConfigHashAnnotation]; ok || skipped.Labels["nodelocaldns.io/injected"] != "" {
+		t.Errorf("skipped pod is marked as injected: %v %v", skipped.Annotations, skipped.Labels)
+	}
+
+	// Recording the same decision again needs no patch
+	if _, response, _ := admitPodIn(t, s, "shop", skipped); response.Patch != nil {
+		t.Errorf("patch = %s, want none", response.Patch)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// CertReloadInterval is how often the certificate files are re-read in case a file event was missed
+const CertReloadInterval = time.Minute
+
+// CertWatcher serves a TLS key pair from disk and reloads it when the files change
+type CertWatcher struct {
+	logger   logr.Logger
+	certFile string
+	keyFile  string
+
+	// current is the key pair being served, with Leaf populated
+	current atomic.Pointer[tls.Certificate]
+}
+
+// NewCertWatcher creates a certificate watcher and loads the initial key pair
+func NewCertWatcher(logger logr.Logger, certFile, keyFile string) (*CertWatcher, error) {
+	w := &CertWatcher{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Start watches the certificate files until the context is cancelled
+func (w *CertWatcher) Start(ctx context.Context) error {
+	return watchFiles(ctx, w.logger, []string{w.certFile, w.keyFile}, CertReloadInterval, w.tryReload)
+}
+
+// watchFiles calls reload whenever one of the files changes and every interval in case a
+// file event was missed, until the context is cancelled
+func watchFiles(ctx context.Context, logger logr.Logger, files []string, interval time.Duration, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	// Watch the directories rather than the files, since mounted secrets and config maps
+	// are updated by atomically swapping a symlink
+	dirs := make(map[string]struct{})
+	for _, file := range files {
+		dirs[filepath.Dir(file)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				logger.V(3).Info("Watched files changed", "event", event.String())
+				reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error(err, "File watcher error")
+			case <-ticker.C:
+				reload()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// GetCertificate returns the current key pair, for use as tls.Config.GetCertificate
+func (w *CertWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return w.current.Load(), nil
+}
+
+// Leaf returns the parsed leaf of the current certificate
+func (w *CertWatcher) Leaf() *x509.Certificate {
+	if cert := w.current.Load(); cert != nil {
+		return cert.Leaf
+	}
+	return nil
+}
+
+// tryReload reloads the key pair, keeping the current one on error
+func (w *CertWatcher) tryReload() {
+	if err := w.reload(); err != nil {
+		recordError(ErrorClassCertificate)
+		w.logger.Error(err, "Failed to reload certificate, keeping the current one")
+	}
+}
+
+// reload loads and validates the key pair, then swaps it in if it differs from the current one
+func (w *CertWatcher) reload() error {
+	certPEM, err := os.ReadFile(w.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(w.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %w", err)
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if swapCertificate(&w.current, cert) {
+		w.logger.Info("Loaded serving certificate",
+			"subject", cert.Leaf.Subject.String(),
+			"notAfter", cert.Leaf.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+// parseKeyPair parses and validates a PEM encoded key pair, populating its Leaf
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate pair: %w", err)
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("certificate is empty")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	return &cert, nil
+}
+
+// swapCertificate stores cert as the current key pair unless it is already current, reporting whether it was swapped
+func swapCertificate(current *atomic.Pointer[tls.Certificate], cert *tls.Certificate) bool {
+	if old := current.Load(); old != nil && old.Leaf.Equal(cert.Leaf) {
+		return false
+	}
+	current.Store(cert)
+	recordCertificateExpiry(cert.Leaf)
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// writeTestKeyPair writes a serving key pair issued at the given time to the files
+func writeTestKeyPair(t *testing.T, now time.Time, certFile, keyFile string) {
+	t.Helper()
+	caCert, caKey, err := generateCA(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := generateServingCert(now, caCert, caKey, []string{"nodelocaldns-webhook.kube-system.svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertWatcher(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, time.Now(), certFile, keyFile)
+
+	w, err := NewCertWatcher(logr.Discard(), certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := w.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	initial, _ := w.GetCertificate(nil)
+
+	// A key pair rotated on disk is served once the files change
+	writeTestKeyPair(t, time.Now(), certFile, keyFile)
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Leaf().Equal(initial.Leaf) {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rotated := w.Leaf()
+
+	// An invalid or expired key pair keeps the current one
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w.tryReload()
+	if !w.Leaf().Equal(rotated) {
+		t.Error("invalid certificate replaced the current one")
+	}
+	writeTestKeyPair(t, time.Now().Add(-2*ServingCertValidity), certFile, keyFile)
+	if err := w.reload(); err == nil {
+		t.Error("expired certificate was loaded")
+	}
+	if !w.Leaf().Equal(rotated) {
+		t.Error("expired certificate replaced the current one")
+	}
+}
+
+func TestNewCertWatcherErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if _, err := NewCertWatcher(logr.Discard(), certFile, keyFile); err == nil {
+		t.Error("NewCertWatcher succeeded without certificate files")
+	}
+
+	// A key that does not match the certificate is rejected
+	writeTestKeyPair(t, time.Now(), certFile, keyFile)
+	otherKey := filepath.Join(dir, "other.key")
+	writeTestKeyPair(t, time.Now(), filepath.Join(dir, "other.crt"), otherKey)
+	if _, err := NewCertWatcher(logr.Discard(), certFile, otherKey); err == nil {
+		t.Error("NewCertWatcher accepted a mismatched key")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr"
+)
+
+// Reasons a client certificate is rejected
+const (
+	ClientRejectNoCertificate  = "no_certificate"
+	ClientRejectUntrusted      = "untrusted"
+	ClientRejectNameNotAllowed = "name_not_allowed"
+)
+
+// ClientAuth verifies that clients present a certificate signed by a trusted CA whose
+// identity is allowed, so that only the API server can call the webhook
+type ClientAuth struct {
+	logger logr.Logger
+	roots  *x509.CertPool
+	// allowedNames are the subject common names or SANs accepted, any identity if empty
+	allowedNames map[string]struct{}
+}
+
+// NewClientAuth creates a client certificate verifier from a PEM CA bundle file
+func NewClientAuth(logger logr.Logger, caFile string, allowedNames []string) (*ClientAuth, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file %s: %w", caFile, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+	}
+
+	c := &ClientAuth{
+		logger:       logger,
+		roots:        roots,
+		allowedNames: make(map[string]struct{}, len(allowedNames)),
+	}
+	for _, name := range allowedNames {
+		c.allowedNames[name] = struct{}{}
+	}
+	return c, nil
+}
+
+// Apply configures the TLS config to request client certificates and verify them
+func (c *ClientAuth) Apply(config *tls.Config) {
+	// Verification is done in VerifyConnection so that every rejection is logged and counted
+	config.ClientAuth = tls.RequireAnyClientCert
+	config.VerifyConnection = c.verifyConnection
+}
+
+// verifyConnection verifies the client certificate chain and identity of a connection
+func (c *ClientAuth) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return c.reject(ClientRejectNoCertificate, nil, errors.New("client certificate required"))
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return c.reject(ClientRejectUntrusted, leaf, fmt.Errorf("client certificate not trusted: %w", err))
+	}
+
+	if !c.allowed(leaf) {
+		return c.reject(ClientRejectNameNotAllowed, leaf, fmt.Errorf("client identity %q not allowed", leaf.Subject.String()))
+	}
+	return nil
+}
+
+// allowed reports whether the certificate subject common name or any SAN is allowed
+func (c *ClientAuth) allowed(cert *x509.Certificate) bool {
+	if len(c.allowedNames) == 0 {
+		return true
+	}
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if _, ok := c.allowedNames[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reject logs and counts a rejected client, returning the error to fail the handshake with
+func (c *ClientAuth) reject(reason string, cert *x509.Certificate, err error) error {
+	clientRejectionsTotal.WithLabelValues(reason).Inc()
+
+	keysAndValues := []interface{}{"reason", reason}
+	if cert != nil {
+		keysAndValues = append(keysAndValues,
+			"subject", cert.Subject.String(),
+			"dnsNames", cert.DNSNames,
+		)
+	}
+	c.logger.Error(err, "Rejected client certificate", keysAndValues...)
+	return err
+}
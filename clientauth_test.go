@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testClientCert issues a certificate with the given identity and key usage signed by the CA
+func testClientCert(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string, dnsNames []string, usage x509.ExtKeyUsage) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestClientAuth(t *testing.T) {
+	caCert, caKey, err := generateCA(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, otherKey, err := generateCA(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "client-ca.crt")
+	if err := os.WriteFile(caFile, encodeCert(caCert.Raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	apiServer := testClientCert(t, caCert, caKey, "kube-apiserver", nil, x509.ExtKeyUsageClientAuth)
+	tests := []struct {
+		name         string
+		allowedNames []string
+		peers        []*x509.Certificate
+		wantReject   string
+	}{
+		{name: "allowed common name", allowedNames: []string{"kube-apiserver"}, peers: []*x509.Certificate{apiServer}},
+		{name: "allowed SAN", allowedNames: []string{"apiserver.example.com"},
+			peers: []*x509.Certificate{testClientCert(t, caCert, caKey, "kube-apiserver", []string{"apiserver.example.com"}, x509.ExtKeyUsageClientAuth)}},
+		{name: "any identity", peers: []*x509.Certificate{apiServer}},
+		{name: "no certificate", wantReject: ClientRejectNoCertificate},
+		{name: "untrusted CA", peers: []*x509.Certificate{testClientCert(t, otherCert, otherKey, "kube-apiserver", nil, x509.ExtKeyUsageClientAuth)},
+			wantReject: ClientRejectUntrusted},
+		{name: "server certificate", peers: []*x509.Certificate{testClientCert(t, caCert, caKey, "kube-apiserver", nil, x509.ExtKeyUsageServerAuth)},
+			wantReject: ClientRejectUntrusted},
+		{name: "disallowed identity", allowedNames: []string{"kube-apiserver"},
+			peers:      []*x509.Certificate{testClientCert(t, caCert, caKey, "intruder", []string{"intruder.example.com"}, x509.ExtKeyUsageClientAuth)},
+			wantReject: ClientRejectNameNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientAuth(logr.Discard(), caFile, tt.allowedNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &tls.Config{}
+			c.Apply(config)
+			if config.ClientAuth != tls.RequireAnyClientCert || config.VerifyConnection == nil {
+				t.Fatal("Apply did not require client certificates")
+			}
+
+			var before float64
+			if tt.wantReject != "" {
+				before = testutil.ToFloat64(clientRejectionsTotal.WithLabelValues(tt.wantReject))
+			}
+			err = config.VerifyConnection(tls.ConnectionState{PeerCertificates: tt.peers})
+			if tt.wantReject == "" {
+				if err != nil {
+					t.Errorf("verifyConnection = %v, want the client accepted", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("verifyConnection accepted the client")
+			}
+			if got := testutil.ToFloat64(clientRejectionsTotal.WithLabelValues(tt.wantReject)) - before; got != 1 {
+				t.Errorf("rejections with reason %s increased by %v, want 1", tt.wantReject, got)
+			}
+		})
+	}
+}
+
+func TestNewClientAuthErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.crt")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, caFile := range []string{filepath.Join(dir, "missing.crt"), empty} {
+		if _, err := NewClientAuth(logr.Discard(), caFile, nil); err == nil {
+			t.Errorf("NewClientAuth(%s) succeeded, want an error", caFile)
+		}
+	}
+}
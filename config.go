@@ -3,8 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -12,6 +16,28 @@ const (
 	EnvNodeLocalDNSAddress = "NODE_LOCAL_DNS_ADDRESS"
 	EnvClusterDomain       = "CLUSTER_DOMAIN"
 	EnvDNSOptions          = "DNS_OPTIONS"
+	EnvDNSSearches         = "DNS_SEARCHES"
+	EnvNameservers         = "NAMESERVERS"
+	EnvInjectionStrategy   = "INJECTION_STRATEGY"
+	EnvInjectedLabel       = "INJECTED_LABEL"
+	EnvHostSearches        = "HOST_SEARCHES"
+	EnvAdditionalDomains   = "ADDITIONAL_CLUSTER_DOMAINS"
+	EnvHostResolvConf      = "HOST_RESOLV_CONF"
+
+	EnvWebhookInjectionLabel             = "WEBHOOK_INJECTION_LABEL"
+	EnvWebhookExcludedNamespaces         = "WEBHOOK_EXCLUDED_NAMESPACES"
+	EnvWebhookNamespaceExcludedLabelKeys = "WEBHOOK_NAMESPACE_EXCLUDED_LABEL_KEYS"
+	EnvWebhookObjectExcludedLabelKeys    = "WEBHOOK_OBJECT_EXCLUDED_LABEL_KEYS"
+	EnvWebhookFailurePolicy              = "WEBHOOK_FAILURE_POLICY"
+	EnvWebhookInterceptUpdate            = "WEBHOOK_INTERCEPT_UPDATE"
+	EnvWebhookTimeoutSeconds             = "WEBHOOK_TIMEOUT_SECONDS"
+	EnvWebhookReinvocationPolicy         = "WEBHOOK_REINVOCATION_POLICY"
+)
+
+// Webhook failure policies
+const (
+	FailurePolicyIgnore = "Ignore"
+	FailurePolicyFail   = "Fail"
 )
 
 // Config represents the webhook configuration
@@ -22,26 +48,114 @@ type Config struct {
 	ClusterDomain string `json:"clusterDomain" yaml:"clusterDomain"`
 	// DNSOptions are the DNS options to inject
 	DNSOptions []DNSOption `json:"dnsOptions" yaml:"dnsOptions"`
+	// Strategy is how the DNS configuration is injected, replace, options-only or prepend-nameserver
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// Nameservers are the nameservers to inject in order, each a source name or a static IP
+	Nameservers []string `json:"nameservers" yaml:"nameservers"`
+	// Searches are the search domain templates to inject, such as "{{.Namespace}}.svc.{{.ClusterDomain}}"
+	Searches []string `json:"searches" yaml:"searches"`
+	// AdditionalClusterDomains are further cluster domains, such as clusterset.local for
+	// multi-cluster services, whose namespace and svc domains are searched too
+	AdditionalClusterDomains []ClusterDomain `json:"additionalClusterDomains,omitempty" yaml:"additionalClusterDomains,omitempty"`
+	// HostSearches are the node search domains appended after the cluster ones, as kubelet does for ClusterFirst
+	HostSearches []string `json:"hostSearches,omitempty" yaml:"hostSearches,omitempty"`
+	// HostResolvConf is a file in resolv.conf format, such as a mounted ConfigMap mirroring the
+	// nodes' /etc/resolv.conf, whose search domains are added to HostSearches when loading
+	// and again whenever the file changes
+	HostResolvConf string `json:"hostResolvConf,omitempty" yaml:"hostResolvConf,omitempty"`
 	// ClusterDNSAddress is the discovered cluster DNS service IP
 	ClusterDNSAddress string `json:"clusterDNSAddress" yaml:"clusterDNSAddress"`
+	// Webhook is how the webhook registers itself with the API server
+	Webhook WebhookRegistration `json:"webhook" yaml:"webhook"`
+	// InjectedLabel is a label key set to "true" on injected pods, none if empty
+	InjectedLabel string `json:"injectedLabel,omitempty" yaml:"injectedLabel,omitempty"`
+	// Profiles are named DNS configurations pods or namespaces select, overriding
+	// the nameservers, searches or options that are set
+	Profiles map[string]DNSConfig `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+
+	// configuredHostSearches are the HostSearches configured, without those of HostResolvConf
+	configuredHostSearches []string
+}
+
+// Webhook reinvocation policies, injection is idempotent so IfNeeded is supported
+const (
+	ReinvocationPolicyNever    = "Never"
+	ReinvocationPolicyIfNeeded = "IfNeeded"
+)
+
+// Injection strategies
+const (
+	// StrategyReplace sets dnsPolicy to None with the full DNS configuration
+	StrategyReplace = "replace"
+	// StrategyOptionsOnly keeps the pod's dnsPolicy, such as ClusterFirst, and only adds the DNS options
+	StrategyOptionsOnly = "options-only"
+	// StrategyPrependNameserver keeps the pod's dnsPolicy, such as ClusterFirst, and only adds the
+	// node local DNS address to the nameservers. Kubelet lists the cluster DNS first, so the node
+	// local DNS is only queried when the cluster DNS does not answer.
+	StrategyPrependNameserver = "prepend-nameserver"
+)
+
+// Nameserver sources, resolved to the configured or discovered addresses
+const (
+	NameserverNodeLocal  = "node-local"
+	NameserverClusterDNS = "cluster-dns"
+)
+
+// MaxNameservers is the number of nameservers the pod resolver uses
+const MaxNameservers = 3
+
+// Positions of an additional cluster domain relative to the primary search domains
+const (
+	ClusterDomainBefore = "before"
+	ClusterDomainAfter  = "after"
+)
+
+// ClusterDomain represents an additional cluster domain
+type ClusterDomain struct {
+	// Domain is the cluster domain, such as clusterset.local
+	Domain string `json:"domain" yaml:"domain"`
+	// Position is where its search domains go relative to the primary ones, before or after (default)
+	Position string `json:"position,omitempty" yaml:"position,omitempty"`
+}
+
+// WebhookRegistration represents the settings of the MutatingWebhookConfiguration
+type WebhookRegistration struct {
+	// InjectionLabel is the label key that opts namespaces in with "enabled", "true" or a profile name and pods out with "disabled"
+	InjectionLabel string `json:"injectionLabel" yaml:"injectionLabel"`
+	// ExcludedNamespaces are the namespaces that are never injected
+	ExcludedNamespaces []string `json:"excludedNamespaces" yaml:"excludedNamespaces"`
+	// NamespaceExcludedLabelKeys are label keys whose presence on a namespace excludes it
+	NamespaceExcludedLabelKeys []string `json:"namespaceExcludedLabelKeys" yaml:"namespaceExcludedLabelKeys"`
+	// ObjectExcludedLabelKeys are label keys whose presence on a pod excludes it
+	ObjectExcludedLabelKeys []string `json:"objectExcludedLabelKeys" yaml:"objectExcludedLabelKeys"`
+	// FailurePolicy is the failure policy of the webhook, Ignore or Fail
+	FailurePolicy string `json:"failurePolicy" yaml:"failurePolicy"`
+	// InterceptUpdate is whether pod UPDATE requests are sent to the webhook
+	InterceptUpdate bool `json:"interceptUpdate" yaml:"interceptUpdate"`
+	// TimeoutSeconds is the timeout of a webhook call
+	TimeoutSeconds int32 `json:"timeoutSeconds" yaml:"timeoutSeconds"`
+	// ReinvocationPolicy is whether the webhook is called again after later mutations, Never or IfNeeded
+	ReinvocationPolicy string `json:"reinvocationPolicy" yaml:"reinvocationPolicy"`
 }
 
 // DNSOption represents a DNS configuration option
 type DNSOption struct {
 	// Name is the option name (e.g., "ndots", "timeout")
 	Name string `json:"name" yaml:"name"`
-	// Value is the option value (e.g., "3", "1")
-	Value string `json:"value" yaml:"value"`
+	// Value is the option value (e.g., "3", "1"), empty for flags such as "single-request-reopen"
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
 }
 
 // DNSConfig represents the DNS configuration to be injected into pods
 type DNSConfig struct {
-	// Nameservers is the list of DNS nameserver IP addresses
+	// Nameservers is the list of DNS nameserver IP addresses, source names or IPs in the configuration
 	Nameservers []string `json:"nameservers" yaml:"nameservers"`
-	// Searches is the list of DNS search domains
+	// Searches is the list of DNS search domains, templates in the configuration
 	Searches []string `json:"searches" yaml:"searches"`
 	// Options is the list of DNS resolver options
 	Options []DNSOption `json:"options" yaml:"options"`
+	// Strategy is how the DNS configuration is injected, the global strategy if empty in a profile
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
 }
 
 // DefaultConfig returns a configuration with default values
@@ -54,15 +168,37 @@ func DefaultConfig() *Config {
 			{Name: "attempts", Value: "2"},
 			{Name: "timeout", Value: "1"},
 		},
+		Strategy:          StrategyReplace,
+		Nameservers:       []string{NameserverNodeLocal, NameserverClusterDNS},
+		Searches:          append([]string(nil), DefaultSearchTemplates...),
 		ClusterDNSAddress: "10.96.0.10", // Default fallback
+		Webhook: WebhookRegistration{
+			InjectionLabel:             "node-local-dns-injection",
+			ExcludedNamespaces:         []string{"kube-system", "kube-public", "arms-prom", "security-inspector", "ack-csi-fuse"},
+			NamespaceExcludedLabelKeys: []string{"virtual-node-affinity-injection", "eci", "alibabacloud.com/eci"},
+			ObjectExcludedLabelKeys:    []string{"eci", "alibabacloud.com/eci"},
+			FailurePolicy:              FailurePolicyIgnore,
+			InterceptUpdate:            true,
+			TimeoutSeconds:             10,
+			ReinvocationPolicy:         ReinvocationPolicyNever,
+		},
 	}
 }
 
-// LoadConfig loads configuration from environment variables with the provided cluster DNS IP
-func LoadConfig(clusterDNSIP string) (*Config, error) {
+// LoadConfig loads configuration from the optional config file and environment variables
+// with the provided cluster DNS IP, environment variables taking precedence
+func LoadConfig(path, clusterDNSIP string) (*Config, error) {
 	// Start with default configuration
 	config := DefaultConfig()
 
+	// Load from the config file, which may provide the node local DNS address instead of the environment
+	if path != "" {
+		config.NodeLocalDNSAddress = ""
+		if err := loadFromFile(path, config); err != nil {
+			return nil, fmt.Errorf("failed to load configuration from %s: %w", path, err)
+		}
+	}
+
 	// Load from environment variables
 	if err := loadFromEnvironment(config); err != nil {
 		return nil, fmt.Errorf("failed to load configuration from environment: %w", err)
@@ -71,26 +207,94 @@ func LoadConfig(clusterDNSIP string) (*Config, error) {
 	// Set the discovered cluster DNS IP
 	config.ClusterDNSAddress = clusterDNSIP
 
-	// Validate final configuration
-	if err := validateConfig(config); err != nil {
+	if err := completeConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ReloadHostResolvConf re-reads the search domains of HostResolvConf, returning a copy of the
+// configuration with them, or the configuration itself when they are unchanged
+func (c *Config) ReloadHostResolvConf() (*Config, error) {
+	searches, err := readResolvConfSearches(c.HostResolvConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host search domains from %s: %w", c.HostResolvConf, err)
+	}
+	hostSearches := append(c.configuredHostSearches, searches...)
+	if slices.Equal(hostSearches, c.HostSearches) {
+		return c, nil
+	}
+
+	reloaded := *c
+	reloaded.HostSearches = hostSearches
+	if err := validateConfig(&reloaded); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
+	return &reloaded, nil
+}
+
+// ParseConfig parses a config document as the only source of configuration, ignoring
+// environment variables, with the cluster DNS IP taken from the document if it sets one
+func ParseConfig(data []byte) (*Config, error) {
+	config := DefaultConfig()
+	config.NodeLocalDNSAddress = ""
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if config.NodeLocalDNSAddress == "" {
+		return nil, fmt.Errorf("node local DNS address is required")
+	}
 
+	if err := completeConfig(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+// completeConfig adds the host search domains to a loaded configuration and validates it
+func completeConfig(config *Config) error {
+	// Add the search domains of the nodes' resolv.conf
+	if config.HostResolvConf != "" {
+		searches, err := readResolvConfSearches(config.HostResolvConf)
+		if err != nil {
+			return fmt.Errorf("failed to load host search domains from %s: %w", config.HostResolvConf, err)
+		}
+		config.configuredHostSearches = slices.Clip(config.HostSearches)
+		config.HostSearches = append(config.configuredHostSearches, searches...)
+	}
+
+	// Validate final configuration
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+	return nil
+}
+
+// loadFromFile loads configuration from a YAML file, unknown fields are rejected
+func loadFromFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
 // loadFromEnvironment loads configuration from environment variables
 func loadFromEnvironment(config *Config) error {
-	// Load node local DNS address (REQUIRED)
+	// Load node local DNS address (REQUIRED unless set by the config file)
 	addr := os.Getenv(EnvNodeLocalDNSAddress)
-	if addr == "" {
+	if addr == "" && config.NodeLocalDNSAddress == "" {
 		return fmt.Errorf("node local DNS address is required but not provided via environment variable %s", EnvNodeLocalDNSAddress)
 	}
-
-	if err := validateIPAddress(addr); err != nil {
-		return fmt.Errorf("invalid node local DNS address %s: %w", addr, err)
+	if addr != "" {
+		if err := validateIPAddress(addr); err != nil {
+			return fmt.Errorf("invalid node local DNS address %s: %w", addr, err)
+		}
+		config.NodeLocalDNSAddress = addr
 	}
-	config.NodeLocalDNSAddress = addr
 
 	// Load cluster domain (optional, use default if not provided)
 	if domain := os.Getenv(EnvClusterDomain); domain != "" {
@@ -106,6 +310,73 @@ func loadFromEnvironment(config *Config) error {
 		config.DNSOptions = dnsOptions
 	}
 
+	// Load injection strategy (optional, use default if not provided)
+	if strategy := os.Getenv(EnvInjectionStrategy); strategy != "" {
+		config.Strategy = strategy
+	}
+
+	// Load nameserver composition (optional, use defaults if not provided)
+	if nameservers := os.Getenv(EnvNameservers); nameservers != "" {
+		config.Nameservers = parseList(nameservers)
+	}
+
+	// Load search domain templates (optional, use defaults if not provided)
+	if searches, ok := os.LookupEnv(EnvDNSSearches); ok {
+		config.Searches = parseList(searches)
+	}
+
+	// Load additional cluster domains (optional, none if not provided)
+	if domains, ok := os.LookupEnv(EnvAdditionalDomains); ok {
+		config.AdditionalClusterDomains = parseClusterDomains(domains)
+	}
+
+	// Load host search domains (optional, none if not provided)
+	if searches, ok := os.LookupEnv(EnvHostSearches); ok {
+		config.HostSearches = parseList(searches)
+	}
+	if path := os.Getenv(EnvHostResolvConf); path != "" {
+		config.HostResolvConf = path
+	}
+
+	// Load injected pod label (optional, none if not provided)
+	if label, ok := os.LookupEnv(EnvInjectedLabel); ok {
+		config.InjectedLabel = strings.TrimSpace(label)
+	}
+
+	// Load webhook registration settings (optional, use defaults if not provided)
+	if label := os.Getenv(EnvWebhookInjectionLabel); label != "" {
+		config.Webhook.InjectionLabel = label
+	}
+	if namespaces, ok := os.LookupEnv(EnvWebhookExcludedNamespaces); ok {
+		config.Webhook.ExcludedNamespaces = parseList(namespaces)
+	}
+	if keys, ok := os.LookupEnv(EnvWebhookNamespaceExcludedLabelKeys); ok {
+		config.Webhook.NamespaceExcludedLabelKeys = parseList(keys)
+	}
+	if keys, ok := os.LookupEnv(EnvWebhookObjectExcludedLabelKeys); ok {
+		config.Webhook.ObjectExcludedLabelKeys = parseList(keys)
+	}
+	if policy := os.Getenv(EnvWebhookFailurePolicy); policy != "" {
+		config.Webhook.FailurePolicy = policy
+	}
+	if intercept := os.Getenv(EnvWebhookInterceptUpdate); intercept != "" {
+		value, err := strconv.ParseBool(intercept)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %w", EnvWebhookInterceptUpdate, intercept, err)
+		}
+		config.Webhook.InterceptUpdate = value
+	}
+	if timeout := os.Getenv(EnvWebhookTimeoutSeconds); timeout != "" {
+		value, err := strconv.ParseInt(timeout, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %w", EnvWebhookTimeoutSeconds, timeout, err)
+		}
+		config.Webhook.TimeoutSeconds = int32(value)
+	}
+	if policy := os.Getenv(EnvWebhookReinvocationPolicy); policy != "" {
+		config.Webhook.ReinvocationPolicy = policy
+	}
+
 	return nil
 }
 
@@ -127,15 +398,159 @@ func validateConfig(config *Config) error {
 	}
 
 	// Validate DNS options
-	for _, option := range config.DNSOptions {
+	if err := validateDNSOptions(config.DNSOptions); err != nil {
+		return err
+	}
+
+	// Validate injection strategy
+	if err := validateStrategy(config.Strategy); err != nil {
+		return err
+	}
+
+	// Validate nameserver composition
+	if len(config.Nameservers) == 0 {
+		return fmt.Errorf("at least one nameserver is required")
+	}
+	if err := validateNameservers(config.Nameservers); err != nil {
+		return err
+	}
+
+	// Validate additional cluster domains
+	for _, domain := range config.AdditionalClusterDomains {
+		if err := validateSearchDomain(domain.Domain); err != nil {
+			return fmt.Errorf("invalid additional cluster domain: %w", err)
+		}
+		if domain.Position != "" && domain.Position != ClusterDomainBefore && domain.Position != ClusterDomainAfter {
+			return fmt.Errorf("position of additional cluster domain %s must be %s or %s, got %q",
+				domain.Domain, ClusterDomainBefore, ClusterDomainAfter, domain.Position)
+		}
+	}
+
+	// Validate search domain templates
+	if _, err := compileSearchTemplates(config.Searches); err != nil {
+		return err
+	}
+
+	// Validate host search domains, which must fit the search path with the cluster ones
+	for _, domain := range config.HostSearches {
+		if err := validateSearchDomain(domain); err != nil {
+			return err
+		}
+	}
+	if n := len(withClusterDomains(config, config.Searches)) + len(config.HostSearches); n > MaxSearchDomains {
+		return fmt.Errorf("at most %d search domains are allowed, got %d including host search domains", MaxSearchDomains, n)
+	}
+
+	// Validate DNS profiles
+	for name, profile := range config.Profiles {
+		if err := validateProfile(config, name, &profile); err != nil {
+			return fmt.Errorf("invalid DNS profile %q: %w", name, err)
+		}
+	}
+
+	// Validate injected pod label
+	if config.InjectedLabel != "" {
+		if errs := validation.IsQualifiedName(config.InjectedLabel); len(errs) > 0 {
+			return fmt.Errorf("invalid injected label %q: %s", config.InjectedLabel, strings.Join(errs, ", "))
+		}
+	}
+
+	// Validate webhook registration
+	if err := validateWebhookRegistration(&config.Webhook); err != nil {
+		return fmt.Errorf("invalid webhook registration: %w", err)
+	}
+
+	return nil
+}
+
+// validateDNSOptions validates DNS options, values are optional
+func validateDNSOptions(options []DNSOption) error {
+	for _, option := range options {
 		if strings.TrimSpace(option.Name) == "" {
 			return fmt.Errorf("DNS option name cannot be empty")
 		}
-		if strings.TrimSpace(option.Value) == "" {
-			return fmt.Errorf("DNS option value cannot be empty")
+	}
+	return nil
+}
+
+// validateProfile validates a named DNS profile
+func validateProfile(config *Config, name string, profile *DNSConfig) error {
+	// Profiles are selected through a label value, so names must be valid label values
+	if name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return fmt.Errorf("name is not a valid label value: %s", strings.Join(errs, ", "))
+	}
+	if name == InjectionEnabled || name == InjectionTrue || name == InjectionDisabled {
+		return fmt.Errorf("name is reserved for the injection label")
+	}
+
+	if profile.Strategy != "" {
+		if err := validateStrategy(profile.Strategy); err != nil {
+			return err
 		}
 	}
+	if err := validateNameservers(profile.Nameservers); err != nil {
+		return err
+	}
+	if _, err := compileSearchTemplates(profile.Searches); err != nil {
+		return err
+	}
+	if n := len(withClusterDomains(config, profile.Searches)) + len(config.HostSearches); len(profile.Searches) > 0 && n > MaxSearchDomains {
+		return fmt.Errorf("at most %d search domains are allowed, got %d including host search domains", MaxSearchDomains, n)
+	}
+	return validateDNSOptions(profile.Options)
+}
 
+// validateStrategy validates an injection strategy
+func validateStrategy(strategy string) error {
+	switch strategy {
+	case StrategyReplace, StrategyOptionsOnly, StrategyPrependNameserver:
+		return nil
+	}
+	return fmt.Errorf("injection strategy must be %s, %s or %s, got %q",
+		StrategyReplace, StrategyOptionsOnly, StrategyPrependNameserver, strategy)
+}
+
+// validateNameservers validates a nameserver composition
+func validateNameservers(nameservers []string) error {
+	// The pod resolver uses at most three nameservers, and the API server rejects more
+	if len(nameservers) > MaxNameservers {
+		return fmt.Errorf("at most %d nameservers are allowed, got %d", MaxNameservers, len(nameservers))
+	}
+	seen := make(map[string]bool, len(nameservers))
+	for _, nameserver := range nameservers {
+		if seen[nameserver] {
+			return fmt.Errorf("duplicate nameserver %s", nameserver)
+		}
+		seen[nameserver] = true
+		if nameserver == NameserverNodeLocal || nameserver == NameserverClusterDNS {
+			continue
+		}
+		if err := validateIPAddress(nameserver); err != nil {
+			return fmt.Errorf("nameserver %s is neither %s, %s nor a valid IP address: %w",
+				nameserver, NameserverNodeLocal, NameserverClusterDNS, err)
+		}
+	}
+	return nil
+}
+
+// validateWebhookRegistration validates the webhook registration settings
+func validateWebhookRegistration(webhook *WebhookRegistration) error {
+	if strings.TrimSpace(webhook.InjectionLabel) == "" {
+		return fmt.Errorf("injection label cannot be empty")
+	}
+	if webhook.FailurePolicy != FailurePolicyIgnore && webhook.FailurePolicy != FailurePolicyFail {
+		return fmt.Errorf("failure policy must be %s or %s, got %q", FailurePolicyIgnore, FailurePolicyFail, webhook.FailurePolicy)
+	}
+	if webhook.ReinvocationPolicy != ReinvocationPolicyNever && webhook.ReinvocationPolicy != ReinvocationPolicyIfNeeded {
+		return fmt.Errorf("reinvocation policy must be %s or %s, got %q", ReinvocationPolicyNever, ReinvocationPolicyIfNeeded, webhook.ReinvocationPolicy)
+	}
+	// The API server accepts timeouts between 1 and 30 seconds
+	if webhook.TimeoutSeconds < 1 || webhook.TimeoutSeconds > 30 {
+		return fmt.Errorf("timeout must be between 1 and 30 seconds, got %d", webhook.TimeoutSeconds)
+	}
 	return nil
 }
 
@@ -156,22 +571,28 @@ func validateIPAddress(ip string) error {
 	return nil
 }
 
-// parseDNSOptions parses DNS options from string format "name1:value1,name2:value2"
+// parseDNSOptions parses DNS options from string format "name1:value1,name2:value2,flag"
 func parseDNSOptions(optionsStr string) ([]DNSOption, error) {
 	var options []DNSOption
 
 	pairs := strings.Split(optionsStr, ",")
 	for _, pair := range pairs {
 		parts := strings.Split(strings.TrimSpace(pair), ":")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid DNS option format: %s (expected name:value)", pair)
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid DNS option format: %s (expected name:value or name)", pair)
 		}
 
 		name := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+		var value string
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+			if value == "" {
+				return nil, fmt.Errorf("DNS option value cannot be empty: %s", pair)
+			}
+		}
 
-		if name == "" || value == "" {
-			return nil, fmt.Errorf("DNS option name and value cannot be empty: %s", pair)
+		if name == "" {
+			return nil, fmt.Errorf("DNS option name cannot be empty: %s", pair)
 		}
 
 		options = append(options, DNSOption{
@@ -182,3 +603,27 @@ func parseDNSOptions(optionsStr string) ([]DNSOption, error) {
 
 	return options, nil
 }
+
+// parseClusterDomains parses additional cluster domains from string format "domain1,domain2:before"
+func parseClusterDomains(domainsStr string) []ClusterDomain {
+	var domains []ClusterDomain
+	for _, item := range parseList(domainsStr) {
+		domain, position, _ := strings.Cut(item, ":")
+		domains = append(domains, ClusterDomain{
+			Domain:   strings.TrimSpace(domain),
+			Position: strings.TrimSpace(position),
+		})
+	}
+	return domains
+}
+
+// parseList parses a comma separated list, dropping empty entries
+func parseList(listStr string) []string {
+	var items []string
+	for _, item := range strings.Split(listStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Decision log sinks
+const (
+	DecisionLogStdout = "stdout"
+	DecisionLogFile   = "file"
+	DecisionLogOTLP   = "otlp"
+)
+
+// Default decision log settings
+const (
+	DefaultDecisionLogMaxSize    = 100 * 1024 * 1024
+	DefaultDecisionLogMaxBackups = 3
+
+	// decisionLogBuffer is the number of records waiting to be written before new ones are dropped
+	decisionLogBuffer = 1024
+
+	// redactedValue replaces the value of redacted fields
+	redactedValue = "REDACTED"
+)
+
+// redactors clear the fields of a decision record that may be redacted, by JSON name
+var redactors = map[string]func(*DecisionRecord){
+	"uid":          func(r *DecisionRecord) { r.UID = redactedValue },
+	"namespace":    func(r *DecisionRecord) { r.Namespace = redactedValue },
+	"name":         func(r *DecisionRecord) { r.Name = redactedValue },
+	"generateName": func(r *DecisionRecord) { r.GenerateName = redactedValue },
+	"owner":        func(r *DecisionRecord) { r.Owner = redactedValue },
+	"profile":      func(r *DecisionRecord) { r.Profile = redactedValue },
+}
+
+// DecisionRecord is the structured record of an admission decision
+type DecisionRecord struct {
+	Time         time.Time `json:"time"`
+	UID          string    `json:"uid"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	GenerateName string    `json:"generateName,omitempty"`
+	// Owner is the controller of the pod as kind/name
+	Owner     string `json:"owner,omitempty"`
+	Operation string `json:"operation"`
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason"`
+	Profile   string `json:"profile,omitempty"`
+	// LatencySeconds is how long the decision took
+	LatencySeconds float64 `json:"latencySeconds"`
+	// PatchBytes is the size of the JSON patch, 0 if none
+	PatchBytes int `json:"patchBytes"`
+}
+
+// newDecisionRecord builds the record of an admission decision
+func newDecisionRecord(req *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse,
+	decision string, result *admissionResult, latency time.Duration) *DecisionRecord {
+	record := &DecisionRecord{
+		Time:           time.Now(),
+		UID:            string(req.UID),
+		Namespace:      req.Namespace,
+		Operation:      string(req.Operation),
+		Decision:       decision,
+		Reason:         result.reason,
+		Profile:        result.profile,
+		LatencySeconds: latency.Seconds(),
+		PatchBytes:     len(response.Patch),
+	}
+	if pod := result.pod; pod != nil {
+		record.Name = pod.Name
+		record.GenerateName = pod.GenerateName
+		if owner := pod.owner(); owner != nil {
+			record.Owner = owner.Kind + "/" + owner.Name
+		}
+	}
+	return record
+}
+
+// DecisionLogOptions represents the settings of the decision log
+type DecisionLogOptions struct {
+	// Sink is where records are written, DecisionLogStdout, DecisionLogFile or DecisionLogOTLP
+	Sink string
+	// File is the path of the file sink
+	File string
+	// MaxSize is the size in bytes at which the file sink rotates
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+	// OTLPEndpoint is the URL of the OTLP/HTTP log endpoint, the OTEL_EXPORTER_OTLP_* variables apply if empty
+	OTLPEndpoint string
+	// SampleRate is the fraction of injected and skipped decisions recorded, denials are always recorded
+	SampleRate float64
+	// Redact are the JSON names of the fields whose values are redacted
+	Redact []string
+}
+
+// decisionSink writes decision records
+type decisionSink interface {
+	write(record *DecisionRecord) error
+	close(ctx context.Context) error
+}
+
+// DecisionLog writes decision records to a sink in the background, so that a slow sink
+// drops records rather than delaying admission
+type DecisionLog struct {
+	logger     logr.Logger
+	sink       decisionSink
+	sampleRate float64
+	redact     []func(*DecisionRecord)
+	done       chan struct{}
+
+	// mu guards sending to records against closing it, as handlers may outlive the shutdown
+	mu      sync.RWMutex
+	records chan *DecisionRecord
+	closed  bool
+}
+
+// NewDecisionLog creates a decision log writing to the configured sink
+func NewDecisionLog(ctx context.Context, logger logr.Logger, opts DecisionLogOptions) (*DecisionLog, error) {
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1, got %v", opts.SampleRate)
+	}
+
+	l := &DecisionLog{
+		logger:     logger,
+		sampleRate: opts.SampleRate,
+		records:    make(chan *DecisionRecord, decisionLogBuffer),
+		done:       make(chan struct{}),
+	}
+	for _, field := range opts.Redact {
+		redactor, ok := redactors[field]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be redacted", field)
+		}
+		l.redact = append(l.redact, redactor)
+	}
+
+	switch opts.Sink {
+	case DecisionLogStdout:
+		l.sink = newJSONSink(os.Stdout)
+	case DecisionLogFile:
+		if opts.File == "" {
+			return nil, fmt.Errorf("file sink requires a path")
+		}
+		file, err := newRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		l.sink = newJSONSink(file)
+	case DecisionLogOTLP:
+		sink, err := newOTLPSink(ctx, opts.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		l.sink = sink
+	default:
+		return nil, fmt.Errorf("sink must be %s, %s or %s, got %q", DecisionLogStdout, DecisionLogFile, DecisionLogOTLP, opts.Sink)
+	}
+
+	go l.run()
+	return l, nil
+}
+
+// Record samples, redacts and queues a decision record
+func (l *DecisionLog) Record(record *DecisionRecord) {
+	if record.Decision != DecisionDenied && rand.Float64() >= l.sampleRate {
+		decisionLogRecordsTotal.WithLabelValues("sampled_out").Inc()
+		return
+	}
+	for _, redact := range l.redact {
+		redact(record)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		decisionLogRecordsTotal.WithLabelValues("dropped").Inc()
+		return
+	}
+	select {
+	case l.records <- record:
+	default:
+		decisionLogRecordsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run writes the queued records until the log is closed
+func (l *DecisionLog) run() {
+	defer close(l.done)
+	for record := range l.records {
+		if err := l.sink.write(record); err != nil {
+			decisionLogRecordsTotal.WithLabelValues("error").Inc()
+			l.logger.V(2).Info("Failed to write decision record", "error", err.Error())
+			continue
+		}
+		decisionLogRecordsTotal.WithLabelValues("written").Inc()
+	}
+}
+
+// Close writes the queued records and closes the sink, records added later are dropped
+func (l *DecisionLog) Close(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.records)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return l.sink.close(ctx)
+}
+
+// jsonSink writes records as JSON lines
+type jsonSink struct {
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// newJSONSink creates a sink writing JSON lines to w
+func newJSONSink(w io.Writer) *jsonSink {
+	return &jsonSink{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *jsonSink) write(record *DecisionRecord) error {
+	return s.enc.Encode(record)
+}
+
+func (s *jsonSink) close(context.Context) error {
+	// Leave stdout open
+	if file, ok := s.w.(*rotatingFile); ok {
+		return file.Close()
+	}
+	return nil
+}
+
+// rotatingFile is a file renamed to path.1, path.2 and so on once it reaches its maximum
+// size, keeping a bounded number of backups
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newRotatingFile opens the file for appending
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultDecisionLogMaxSize
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending and reads its current size
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open decision log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat decision log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, dropping the oldest, and starts a new file
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close decision log file: %w", err)
+	}
+	if f.maxBackups == 0 {
+		os.Remove(f.path)
+	} else {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate decision log file: %w", err)
+		}
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+// otlpSink exports records as OpenTelemetry log records over OTLP/HTTP
+type otlpSink struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+// newOTLPSink creates a sink exporting to the endpoint in batches
+func newOTLPSink(ctx context.Context, endpoint string) (*otlpSink, error) {
+	var opts []otlploghttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlploghttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlploghttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP log exporter: %w", err)
+	}
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+		sdklog.WithResource(serviceResource()),
+	)
+	return &otlpSink{
+		provider: provider,
+		logger:   provider.Logger("nodelocaldns-admission-controller"),
+	}, nil
+}
+
+func (s *otlpSink) write(record *DecisionRecord) error {
+	var r otellog.Record
+	r.SetTimestamp(record.Time)
+	r.SetSeverity(otellog.SeverityInfo)
+	r.SetBody(otellog.StringValue("admission " + record.Decision))
+	r.AddAttributes(
+		otellog.String("uid", record.UID),
+		otellog.String("namespace", record.Namespace),
+		otellog.String("name", record.Name),
+		otellog.String("generateName", record.GenerateName),
+		otellog.String("owner", record.Owner),
+		otellog.String("operation", record.Operation),
+		otellog.String("decision", record.Decision),
+		otellog.String("reason", record.Reason),
+		otellog.String("profile", record.Profile),
+		otellog.Float64("latencySeconds", record.LatencySeconds),
+		otellog.Int("patchBytes", record.PatchBytes),
+	)
+	s.logger.Emit(context.Background(), r)
+	return nil
+}
+
+func (s *otlpSink) close(ctx context.Context) error {
+	return s.provider.Shutdown(ctx)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// readDecisionRecords reads the records of a JSON lines file
+func readDecisionRecords(t *testing.T, path string) []DecisionRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []DecisionRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record DecisionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestDecisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	decisionLog, err := NewDecisionLog(context.Background(), logr.Discard(), DecisionLogOptions{
+		Sink:       DecisionLogFile,
+		File:       path,
+		MaxSize:    DefaultDecisionLogMaxSize,
+		SampleRate: 0,
+		Redact:     []string{"name", "owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.decisions = decisionLog
+	s.processAdmissionRequest(context.Background(), benchmarkRequest(t))
+
+	// Denials are recorded regardless of sampling
+	req := benchmarkRequest(t)
+	req.Object.Raw = []byte(`{"metadata":{"name":"web","annotations":{"nodelocaldns.io/profile":"missing"}}}`)
+	s.processAdmissionRequest(context.Background(), req)
+
+	if err := decisionLog.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Records added after closing are dropped
+	s.processAdmissionRequest(context.Background(), req)
+
+	records := readDecisionRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want the denial only", len(records))
+	}
+	record := records[0]
+	if record.Decision != DecisionDenied || record.Reason != ReasonUnknownProfile {
+		t.Errorf("decision = %s/%s, want %s/%s", record.Decision, record.Reason, DecisionDenied, ReasonUnknownProfile)
+	}
+	if record.UID != string(req.UID) || record.Namespace != "shop" || record.Operation != "CREATE" {
+		t.Errorf("record = %+v, want the request fields", record)
+	}
+	if record.Name != redactedValue {
+		t.Errorf("name = %q, want it redacted", record.Name)
+	}
+
+	if _, err := NewDecisionLog(context.Background(), logr.Discard(), DecisionLogOptions{Sink: DecisionLogStdout, Redact: []string{"latencySeconds"}}); err == nil {
+		t.Error("NewDecisionLog accepted redacting a field that cannot be")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	f, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more backups than configured are kept: %v", err)
+	}
+}
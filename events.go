@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// EventComponent is the source of the events the webhook records
+const EventComponent = "nodelocaldns-admission-controller"
+
+// Default rate of the events recorded per object, as a token bucket refilled at
+// DefaultEventQPS up to DefaultEventBurst
+const (
+	DefaultEventQPS   = 1.0 / 60
+	DefaultEventBurst = 10
+)
+
+// EventReasonSearchDomainsTruncated is the reason of the events about injected pods whose
+// search domains were dropped beyond the search path limits
+const EventReasonSearchDomainsTruncated = "SearchDomainsTruncated"
+
+// skipEventMessages explain the skip reasons worth telling the pod's owner about
+var skipEventMessages = map[string]string{
+	ReasonExistingDNSConfig: "the pod sets its own dnsConfig",
+	ReasonDNSPolicyNone:     "the pod sets dnsPolicy None",
+	ReasonDNSPolicyDefault:  "the pod sets dnsPolicy Default",
+	ReasonHostNetwork:       "the pod uses the host network without dnsPolicy ClusterFirstWithHostNet",
+	ReasonOverloaded:        "the webhook was overloaded",
+}
+
+// failureReasons are the reasons of admissions failing internally rather than being denied
+var failureReasons = map[string]bool{
+	ReasonDecodeError: true,
+	ReasonPatchError:  true,
+}
+
+// NewEventBroadcaster creates a broadcaster recording events to the API server. Events
+// are rate limited per object and similar ones aggregated, so that a rollout of many
+// pods skipping injection results in a handful of events.
+func NewEventBroadcaster(ctx context.Context, client kubernetes.Interface, qps float32, burst int) (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster(
+		record.WithContext(ctx),
+		record.WithCorrelatorOptions(record.CorrelatorOptions{
+			QPS:       qps,
+			BurstSize: burst,
+		}),
+	)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: EventComponent})
+	return broadcaster, recorder
+}
+
+// recordEvent records an event for denied, failed and noteworthy skipped admissions
+func (s *Server) recordEvent(req *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse,
+	decision string, result *admissionResult) {
+	if s.events == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+
+	eventType, reason, message := corev1.EventTypeNormal, result.reason, ""
+	switch {
+	case failureReasons[result.reason]:
+		eventType, message = corev1.EventTypeWarning, "failed: "+response.Result.Message
+	case decision == DecisionDenied:
+		eventType, message = corev1.EventTypeWarning, "denied: "+response.Result.Message
+	case decision == DecisionSkipped && skipEventMessages[result.reason] != "":
+		message = "skipped: " + skipEventMessages[result.reason]
+	case decision == DecisionInjected && result.truncated != "":
+		eventType, reason, message = corev1.EventTypeWarning, EventReasonSearchDomainsTruncated, result.truncated
+	default:
+		return
+	}
+
+	s.events.Eventf(eventTarget(req.Namespace, result.pod), eventType, reason,
+		"NodeLocal DNS injection into %s %s", describePod(result.pod), message)
+}
+
+// eventTarget returns the object events about a pod are attached to: its owner, the pod
+// itself when it has a name, or otherwise its namespace
+func eventTarget(namespace string, pod *podMetadata) *corev1.ObjectReference {
+	if pod != nil && pod.Namespace != "" {
+		namespace = pod.Namespace
+	}
+	if pod != nil {
+		if owner := pod.owner(); owner != nil {
+			return &corev1.ObjectReference{
+				APIVersion: owner.APIVersion,
+				Kind:       owner.Kind,
+				Name:       owner.Name,
+				Namespace:  namespace,
+				UID:        owner.UID,
+			}
+		}
+		if pod.Name != "" {
+			return &corev1.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				Namespace:  namespace,
+			}
+		}
+	}
+	// Events are stored in the namespace of their object, which makes those of the
+	// cluster scoped namespace show up among the events of the namespace itself
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace,
+		Namespace:  namespace,
+	}
+}
+
+// describePod names a pod in event messages, by its name prefix when it is not named yet
+func describePod(pod *podMetadata) string {
+	switch {
+	case pod == nil:
+		return "pod"
+	case pod.Name != "":
+		return fmt.Sprintf("pod %s", pod.Name)
+	case pod.GenerateName != "":
+		return fmt.Sprintf("pod %s*", pod.GenerateName)
+	default:
+		return "unnamed pod"
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		pod    string
+		dryRun bool
+		want   string
+	}{
+		{
+			name: "skipped pod of a workload",
+			pod:  `{"metadata":{"generateName":"web-","ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"web","uid":"1","controller":true}]},"spec":{"dnsConfig":{}}}`,
+			want: "Normal ExistingDNSConfig NodeLocal DNS injection into pod web-* skipped: the pod sets its own dnsConfig involvedObject{kind=ReplicaSet,apiVersion=apps/v1}",
+		},
+		{
+			name: "skipped named pod",
+			pod:  `{"metadata":{"name":"web"},"spec":{"dnsPolicy":"None"}}`,
+			want: "Normal DNSPolicyNone NodeLocal DNS injection into pod web skipped: the pod sets dnsPolicy None involvedObject{kind=Pod,apiVersion=v1}",
+		},
+		{
+			name: "skipped pod using the node resolv.conf",
+			pod:  `{"metadata":{"name":"web"},"spec":{"dnsPolicy":"Default"}}`,
+			want: "Normal DNSPolicyDefault NodeLocal DNS injection into pod web skipped: the pod sets dnsPolicy Default involvedObject{kind=Pod,apiVersion=v1}",
+		},
+		{
+			name: "denied pod without a name",
+			pod:  `{"metadata":{"annotations":{"nodelocaldns.io/profile":"missing"}}}`,
+			want: `Warning UnknownProfile NodeLocal DNS injection into unnamed pod denied: unknown DNS profile "missing" involvedObject{kind=Namespace,apiVersion=v1}`,
+		},
+		{
+			name: "pod failing to decode",
+			pod:  `{"metadata":[]}`,
+			want: "Warning DecodeError NodeLocal DNS injection into pod failed: Failed to parse pod: json: cannot unmarshal array into Go struct field podInfo.metadata of type main.podMetadata involvedObject{kind=Namespace,apiVersion=v1}",
+		},
+		{
+			name: "injected pod",
+			pod:  `{"metadata":{"name":"web"}}`,
+		},
+		{
+			name:   "dry run",
+			pod:    `{"metadata":{"name":"web"},"spec":{"dnsPolicy":"None"}}`,
+			dryRun: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			recorder.IncludeObject = true
+			s := newTestServer()
+			s.events = recorder
+
+			req := benchmarkRequest(t)
+			req.Object.Raw = []byte(tt.pod)
+			req.DryRun = ptr.To(tt.dryRun)
+			s.processAdmissionRequest(context.Background(), req)
+
+			var got string
+			select {
+			case got = <-recorder.Events:
+			default:
+			}
+			if got != tt.want {
+				t.Errorf("event = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchDomainsTruncatedEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	s := newTestServer()
+	s.events = recorder
+	cfg := DefaultConfig()
+	for i := 0; i < MaxSearchDomains; i++ {
+		cfg.HostSearches = append(cfg.HostSearches, fmt.Sprintf("site-%d.example.com", i))
+	}
+	s.SetConfig(cfg)
+
+	req := benchmarkRequest(t)
+	req.Object.Raw = []byte(`{"metadata":{"name":"web"}}`)
+	response := s.processAdmissionRequest(context.Background(), req)
+	if len(response.Warnings) != 1 {
+		t.Fatalf("warnings = %q, want the dropped search domains", response.Warnings)
+	}
+
+	select {
+	case got := <-recorder.Events:
+		want := "Warning SearchDomainsTruncated NodeLocal DNS injection into pod web " + response.Warnings[0]
+		if got != want {
+			t.Errorf("event = %q, want %q", got, want)
+		}
+	default:
+		t.Fatal("no event recorded")
+	}
+	if !strings.Contains(response.Warnings[0], `"site-31.example.com"`) {
+		t.Errorf("warning = %q, want the last host search domains dropped", response.Warnings[0])
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// KRM function ResourceList, as read from stdin and written back by kustomize and kpt
+const (
+	ResourceListAPIVersion = "config.kubernetes.io/v1"
+	ResourceListKind       = "ResourceList"
+)
+
+// Severities of KRM function results, pipelines fail on errors
+const (
+	ResultSeverityError   = "error"
+	ResultSeverityWarning = "warning"
+	ResultSeverityInfo    = "info"
+)
+
+// pathAnnotations hold the file of a resource in a pipeline, the current one last so it wins
+var pathAnnotations = []string{"config.kubernetes.io/path", "internal.config.kubernetes.io/path"}
+
+// resourceList is the input and output of a KRM function
+type resourceList struct {
+	APIVersion     string                   `json:"apiVersion"`
+	Kind           string                   `json:"kind"`
+	Items          []map[string]interface{} `json:"items"`
+	FunctionConfig map[string]interface{}   `json:"functionConfig,omitempty"`
+	Results        []krmResult              `json:"results,omitempty"`
+}
+
+// krmResult reports the decision on a resource to the pipeline
+type krmResult struct {
+	Message     string          `json:"message"`
+	Severity    string          `json:"severity"`
+	ResourceRef *krmResourceRef `json:"resourceRef,omitempty"`
+	File        *krmFile        `json:"file,omitempty"`
+	Tags        krmTags         `json:"tags,omitempty"`
+}
+
+// krmResourceRef identifies the resource a result is about
+type krmResourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// krmFile is the file holding the resource a result is about
+type krmFile struct {
+	Path string `json:"path"`
+}
+
+// krmTags are the decision and reason codes of a result, for pipelines to filter on
+type krmTags map[string]string
+
+// functionConfigToConfig parses the functionConfig of a ResourceList, whose fields besides
+// the KRM ones are those of the config file
+func functionConfigToConfig(functionConfig map[string]interface{}) (*Config, error) {
+	if functionConfig == nil {
+		return nil, fmt.Errorf("functionConfig is required")
+	}
+	fields := make(map[string]interface{}, len(functionConfig))
+	for key, value := range functionConfig {
+		switch key {
+		case "apiVersion", "kind", "metadata":
+		default:
+			fields[key] = value
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal functionConfig: %w", err)
+	}
+	return ParseConfig(data)
+}
+
+// processResourceList applies DNS injection to the items of a ResourceList and replaces
+// its results with the decisions, returning the number of denials
+func processResourceList(ctx context.Context, list *resourceList) (int, error) {
+	cfg, err := functionConfigToConfig(list.FunctionConfig)
+	if err != nil {
+		return 0, err
+	}
+	namespaces, err := namespaceLister(list.Items)
+	if err != nil {
+		return 0, err
+	}
+	renderer := &manifestRenderer{server: newOfflineServer(cfg, namespaces), namespace: metav1.NamespaceDefault}
+
+	denied := 0
+	list.Results = nil
+	for i, item := range list.Items {
+		rendered, err := renderer.render(ctx, item)
+		if err != nil {
+			return 0, fmt.Errorf("failed to render %s: %w", describeObject(item, metav1.NamespaceDefault), err)
+		}
+		list.Items[i] = rendered.object
+		if rendered.reason == ReasonNotPod {
+			continue
+		}
+		list.Results = append(list.Results, krmResults(item, rendered)...)
+		if rendered.decision == DecisionDenied {
+			denied++
+		}
+	}
+	return denied, nil
+}
+
+// krmResults returns the results reporting the decision on a resource and its warnings
+func krmResults(item map[string]interface{}, rendered *renderedObject) []krmResult {
+	u := &unstructured.Unstructured{Object: item}
+	ref := &krmResourceRef{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Name:       u.GetName(),
+		Namespace:  u.GetNamespace(),
+	}
+	var file *krmFile
+	for _, annotation := range pathAnnotations {
+		if path := u.GetAnnotations()[annotation]; path != "" {
+			file = &krmFile{Path: path}
+		}
+	}
+	tags := krmTags{"decision": rendered.decision, "reason": rendered.reason}
+	if rendered.profile != "" {
+		tags["profile"] = rendered.profile
+	}
+
+	result := krmResult{
+		Message:     fmt.Sprintf("NodeLocal DNS injection %s (%s)", rendered.decision, rendered.reason),
+		Severity:    ResultSeverityInfo,
+		ResourceRef: ref,
+		File:        file,
+		Tags:        tags,
+	}
+	if rendered.decision == DecisionDenied {
+		result.Message += ": " + rendered.message
+		result.Severity = ResultSeverityError
+	}
+	results := []krmResult{result}
+	for _, warning := range rendered.warnings {
+		results = append(results, krmResult{
+			Message:     warning,
+			Severity:    ResultSeverityWarning,
+			ResourceRef: ref,
+			File:        file,
+			Tags:        tags,
+		})
+	}
+	return results
+}
+
+// runKRMFunction implements the KRM function command, reading a ResourceList from stdin
+// and writing it back with the pods injected, exiting with status 1 if a pod is denied
+func runKRMFunction(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(KRMFunctionCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s < resource-list.yaml\n\n", os.Args[0], KRMFunctionCommand)
+		fmt.Fprintf(stderr, "Runs as a KRM function for kustomize and kpt: applies the DNS injection of the webhook to\n")
+		fmt.Fprintf(stderr, "the Pods and workloads of a ResourceList, with its functionConfig as the config file,\n")
+		fmt.Fprintf(stderr, "and reports the decisions in its results. Denials are errors and fail the pipeline.\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to read ResourceList: %v\n", err)
+		return 1
+	}
+	var list resourceList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		fmt.Fprintf(stderr, "Failed to parse ResourceList: %v\n", err)
+		return 1
+	}
+	if list.Kind != ResourceListKind {
+		fmt.Fprintf(stderr, "Expected a %s, got kind %q\n", ResourceListKind, list.Kind)
+		return 1
+	}
+
+	denied, err := processResourceList(context.Background(), &list)
+	if err != nil {
+		// Report the failure in the results as well, pipelines show them
+		list.Results = []krmResult{{Message: err.Error(), Severity: ResultSeverityError}}
+	}
+	if list.APIVersion == "" {
+		list.APIVersion = ResourceListAPIVersion
+	}
+	output, marshalErr := yaml.Marshal(&list)
+	if marshalErr != nil {
+		fmt.Fprintf(stderr, "Failed to marshal ResourceList: %v\n", marshalErr)
+		return 1
+	}
+	stdout.Write(output)
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	case denied > 0:
+		fmt.Fprintf(stderr, "%d of %d resources denied\n", denied, len(list.Items))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const testResourceList = `apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  apiVersion: nodelocaldns.io/v1alpha1
+  kind: NodeLocalDNSConfig
+  metadata:
+    name: dns
+  nodeLocalDNSAddress: 169.254.20.10
+  clusterDNSAddress: 10.0.0.10
+items:
+- apiVersion: apps/v1
+  kind: StatefulSet
+  metadata:
+    name: db
+    namespace: shop
+    annotations:
+      internal.config.kubernetes.io/path: shop/db.yaml
+  spec:
+    template:
+      spec:
+        containers:
+        - name: db
+          image: postgres
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: db
+    namespace: shop
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: debug
+    namespace: shop
+    annotations:
+      nodelocaldns.io/profile: missing
+  spec:
+    containers:
+    - name: debug
+      image: busybox
+`
+
+func TestKRMFunction(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runKRMFunction(nil, strings.NewReader(testResourceList), &stdout, &stderr); code != 1 {
+		t.Errorf("exit status = %d, want 1 for the denied pod", code)
+	}
+
+	var list resourceList
+	if err := yaml.Unmarshal(stdout.Bytes(), &list); err != nil {
+		t.Fatalf("invalid output: %v\n%s", err, stdout.String())
+	}
+	if list.Kind != ResourceListKind || len(list.Items) != 3 || list.FunctionConfig == nil {
+		t.Fatalf("output is not the ResourceList:\n%s", stdout.String())
+	}
+
+	var statefulSet struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					DNSConfig struct {
+						Nameservers []string `json:"nameservers"`
+					} `json:"dnsConfig"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	data, err := yaml.Marshal(list.Items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(statefulSet.Spec.Template.Spec.DNSConfig.Nameservers, ","); got != "169.254.20.10,10.0.0.10" {
+		t.Errorf("nameservers = %s, want those of the functionConfig", got)
+	}
+
+	if len(list.Results) != 2 {
+		t.Fatalf("got %d results, want one per pod: %+v", len(list.Results), list.Results)
+	}
+	injected, denied := list.Results[0], list.Results[1]
+	if injected.Severity != ResultSeverityInfo || injected.Tags["reason"] != ReasonInjected ||
+		injected.ResourceRef.Kind != "StatefulSet" || injected.File == nil || injected.File.Path != "shop/db.yaml" {
+		t.Errorf("result = %+v, want the injected StatefulSet", injected)
+	}
+	if denied.Severity != ResultSeverityError || denied.Tags["reason"] != ReasonUnknownProfile || denied.ResourceRef.Name != "debug" {
+		t.Errorf("result = %+v, want the denied pod as an error", denied)
+	}
+
+	// The function fails without a usable configuration
+	stdout.Reset()
+	invalid := strings.Replace(testResourceList, "169.254.20.10", "invalid", 1)
+	if code := runKRMFunction(nil, strings.NewReader(invalid), &stdout, &stderr); code != 1 || !strings.Contains(stdout.String(), "severity: error") {
+		t.Errorf("exit status = %d with output %s, want an error result", code, stdout.String())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// Leader election timings
+	LeaseDuration = 15 * time.Second
+	RenewDeadline = 10 * time.Second
+	RetryPeriod   = 2 * time.Second
+)
+
+// StartLeaderElection campaigns for the named lease in the background and runs
+// every given function while this replica leads. Leadership is campaigned for
+// again after it is lost, until the context is cancelled.
+func StartLeaderElection(ctx context.Context, logger logr.Logger, client kubernetes.Interface, namespace, name, identity string, runs ...func(context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   LeaseDuration,
+		RenewDeadline:   RenewDeadline,
+		RetryPeriod:     RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("Started leading", "lease", namespace+"/"+name, "identity", identity)
+
+				var wg sync.WaitGroup
+				for _, run := range runs {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						run(ctx)
+					}()
+				}
+				wg.Wait()
+			},
+			OnStoppedLeading: func() {
+				logger.Info("Stopped leading", "lease", namespace+"/"+name, "identity", identity)
+			},
+		},
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, config)
+		}
+	}()
+}
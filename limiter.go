@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Decisions for admission requests shed under overload
+const (
+	OverloadAllow = "allow"
+	OverloadDeny  = "deny"
+)
+
+// Default load shedding settings
+const (
+	DefaultMaxQueued    = 64
+	DefaultQueueTimeout = 100 * time.Millisecond
+)
+
+// admissionLimiter bounds the admission requests handled concurrently, letting a
+// few more wait briefly for a slot
+type admissionLimiter struct {
+	slots        chan struct{}
+	queued       atomic.Int64
+	maxQueued    int64
+	queueTimeout time.Duration
+}
+
+// newAdmissionLimiter creates a limiter of maxInFlight concurrent requests
+func newAdmissionLimiter(maxInFlight, maxQueued int, queueTimeout time.Duration) *admissionLimiter {
+	if queueTimeout <= 0 {
+		queueTimeout = DefaultQueueTimeout
+	}
+	return &admissionLimiter{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueued:    int64(maxQueued),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room, and reports whether it got one
+func (l *admissionLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		admissionInFlight.Inc()
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueued {
+		l.queued.Add(-1)
+		return false
+	}
+	admissionQueueDepth.Inc()
+	defer func() {
+		l.queued.Add(-1)
+		admissionQueueDepth.Dec()
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		admissionInFlight.Inc()
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *admissionLimiter) release() {
+	<-l.slots
+	admissionInFlight.Dec()
+}
+
+// limitAdmission handles admission requests within the limiter, answering requests
+// that cannot get a slot with the configured overload decision instead of letting them
+// time out. Only the request header is decoded from the body to answer with its UID.
+func (s *Server) limitAdmission(limiter *admissionLimiter, decision string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter.acquire(r.Context()) {
+			defer limiter.release()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestBytes))
+		if err != nil {
+			recordError(ErrorClassReadBody)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				s.writeErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			s.writeErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		header, err := peekAdmissionRequest(body)
+		if err != nil {
+			recordError(ErrorClassDecode)
+			s.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid admission review: %v", err))
+			return
+		}
+		setAdmissionUID(r.Context(), header.UID)
+		admissionShedTotal.WithLabelValues(decision).Inc()
+
+		s.logger.V(2).Info("Shedding admission request", "uid", header.UID, "decision", decision)
+		response := &admissionv1.AdmissionResponse{
+			UID:     header.UID,
+			Allowed: true,
+		}
+		reason, result := ReasonOverloaded, DecisionSkipped
+		if decision == OverloadDeny {
+			response = s.createErrorResponse(string(header.UID), "Webhook overloaded, retry later")
+			response.Result.Code = http.StatusTooManyRequests
+			result = DecisionDenied
+		}
+		recordAdmission(header.Operation, result, reason)
+		// Only pod creations are worth an event, updates never need a patch
+		if header.Operation == admissionv1.Create {
+			s.recordEvent(&admissionv1.AdmissionRequest{Namespace: header.Namespace, Operation: header.Operation, DryRun: header.DryRun},
+				response, result, &admissionResult{reason: reason})
+		}
+		if s.decisions != nil {
+			s.decisions.Record(&DecisionRecord{
+				Time:      time.Now(),
+				UID:       string(header.UID),
+				Namespace: header.Namespace,
+				Operation: string(header.Operation),
+				Decision:  result,
+				Reason:    reason,
+			})
+		}
+		s.writeAdmissionReview(w, response)
+	})
+}
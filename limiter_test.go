@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestLimitAdmission(t *testing.T) {
+	s := newTestServer()
+	s.maxRequestBytes = int64(len(testPodReview))
+
+	// The only slot is taken and nothing may queue, so every request is shed
+	limiter := newAdmissionLimiter(1, 0, 0)
+	if !limiter.acquire(context.Background()) {
+		t.Fatal("failed to take the only slot")
+	}
+	defer limiter.release()
+
+	tests := []struct {
+		name        string
+		decision    string
+		body        string
+		wantStatus  int
+		wantAllowed bool
+		wantShed    float64
+	}{
+		{name: "allowed", decision: OverloadAllow, body: testPodReview, wantStatus: http.StatusOK, wantAllowed: true, wantShed: 1},
+		{name: "denied", decision: OverloadDeny, body: testPodReview, wantStatus: http.StatusOK, wantShed: 1},
+		{name: "invalid review", decision: OverloadAllow, body: `{"request":{}}`, wantStatus: http.StatusBadRequest},
+		{name: "body too large", decision: OverloadAllow, body: testPodReview + strings.Repeat(" ", 16), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := s.recoverAdmission(s.limitAdmission(limiter, tt.decision, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				t.Error("request was handled without a slot")
+			})))
+
+			before := testutil.ToFloat64(admissionShedTotal.WithLabelValues(tt.decision))
+			rec := postReview(handler, ContentTypeJSON, []byte(tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := testutil.ToFloat64(admissionShedTotal.WithLabelValues(tt.decision)) - before; got != tt.wantShed {
+				t.Errorf("shed requests increased by %v, want %v", got, tt.wantShed)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if review.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" || review.Response.Allowed != tt.wantAllowed {
+				t.Errorf("response = %+v, want the request UID allowed = %v", review.Response, tt.wantAllowed)
+			}
+		})
+	}
+}
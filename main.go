@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2/textlogger"
 )
 
@@ -17,35 +24,122 @@ var (
 	certFile     = flag.String("cert-file", "/etc/certs/tls.crt", "Path to TLS certificate file")
 	keyFile      = flag.String("key-file", "/etc/certs/tls.key", "Path to TLS private key file")
 	port         = flag.Int("port", 8443, "Port to listen on")
+	opsPort      = flag.Int("ops-port", 8080, "Port of the plain HTTP listener serving metrics and health probes, 0 to disable")
 	logVerbosity = flag.Int("log-verbosity", 1, "Log verbosity")
+	configFile   = flag.String("config", "", "Path to a YAML config file, such as one defining DNS profiles, environment variables take precedence")
+
+	selfSignedCerts   = flag.Bool("self-signed-certs", false, "Generate a self-signed CA and serving certificate instead of reading them from files")
+	certSecretName    = flag.String("cert-secret-name", "nodelocaldns-webhook-self-signed", "Name of the Secret holding the self-signed certificates")
+	namespace         = flag.String("namespace", "kube-system", "Namespace of the webhook service")
+	serviceName       = flag.String("service-name", "nodelocaldns-webhook", "Name of the webhook service")
+	webhookConfigName = flag.String("webhook-config-name", "nodelocaldns-admission-controller", "Name of the MutatingWebhookConfiguration")
+
+	manageWebhookConfig   = flag.Bool("manage-webhook-config", false, "Create the MutatingWebhookConfiguration from config and repair drift")
+	caFile                = flag.String("ca-file", "", "Path to the CA bundle written to the MutatingWebhookConfiguration, the live caBundle is kept if empty, unused with --self-signed-certs")
+	servicePort           = flag.Int("service-port", 443, "Port of the webhook service")
+	clientCAFile          = flag.String("client-ca-file", "", "Path to the CA bundle verifying client certificates, client certificates are not required if empty")
+	clientAllowedNames    = flag.String("client-allowed-names", "", "Comma separated subject common names or SANs of the accepted client certificates, any if empty")
+	tlsMinVersion         = flag.String("tls-min-version", "1.2", "Minimum TLS version, 1.2 or 1.3")
+	tlsMaxVersion         = flag.String("tls-max-version", "", "Maximum TLS version, 1.2 or 1.3, the latest supported if empty")
+	tlsCipherSuites       = flag.String("tls-cipher-suites", "", "Comma separated TLS 1.2 cipher suites, Go defaults if empty")
+	tlsCurvePreferences   = flag.String("tls-curve-preferences", "", "Comma separated key exchange curves in preference order, Go defaults if empty")
+	http2                 = flag.Bool("http2", true, "Negotiate HTTP/2 on the webhook listener")
+	maxRequestBytes       = flag.Int64("max-request-bytes", DefaultMaxRequestBytes, "Maximum size of an admission request body")
+	shutdownDrainPeriod   = flag.Duration("shutdown-drain-period", DefaultShutdownDrainPeriod, "How long readiness fails before the listener is shut down on termination")
+	shutdownGracePeriod   = flag.Duration("shutdown-grace-period", DefaultShutdownGracePeriod, "How long in-flight requests may take to finish on shutdown")
+	maxInFlight           = flag.Int("max-in-flight", 0, "Maximum admission requests handled concurrently, unlimited if 0")
+	maxQueued             = flag.Int("max-queued", DefaultMaxQueued, "Maximum admission requests waiting for a slot when --max-in-flight is reached")
+	queueTimeout          = flag.Duration("queue-timeout", DefaultQueueTimeout, "How long an admission request waits for a slot before being shed")
+	overloadDecision      = flag.String("overload-decision", OverloadAllow, "Answer to shed admission requests, allow (without patch) or deny")
+	decisionLogSink       = flag.String("decision-log", "", "Sink of the structured admission decision records, stdout, file or otlp, none if empty")
+	decisionLogFile       = flag.String("decision-log-file", "/var/log/nodelocaldns-webhook/decisions.log", "Path of the decision log with --decision-log=file")
+	decisionLogMaxSize    = flag.Int64("decision-log-max-size", DefaultDecisionLogMaxSize, "Size in bytes at which the decision log file rotates")
+	decisionLogMaxBackups = flag.Int("decision-log-max-backups", DefaultDecisionLogMaxBackups, "Number of rotated decision log files kept")
+	decisionLogEndpoint   = flag.String("decision-log-otlp-endpoint", "", "URL of the OTLP/HTTP logs endpoint with --decision-log=otlp, OTEL_EXPORTER_OTLP_* variables apply if empty")
+	decisionLogSampleRate = flag.Float64("decision-log-sample-rate", 1, "Fraction of injected and skipped decisions recorded, denials are always recorded")
+	decisionLogRedact     = flag.String("decision-log-redact", "", "Comma separated decision record fields whose values are redacted: uid, namespace, name, generateName, owner, profile")
+	tracing               = flag.Bool("tracing", false, "Trace admission requests and export the spans over OTLP/HTTP")
+	tracingEndpoint       = flag.String("tracing-otlp-endpoint", "", "URL of the OTLP/HTTP traces endpoint, OTEL_EXPORTER_OTLP_* variables apply if empty")
+	tracingSampleRatio    = flag.Float64("tracing-sample-ratio", 1, "Fraction of the admission requests traced when the caller did not decide")
+	events                = flag.Bool("events", true, "Record Kubernetes Events about denied, failed and noteworthy skipped injections")
+	eventQPS              = flag.Float64("event-qps", DefaultEventQPS, "Rate at which events are recorded per object once --event-burst is spent")
+	eventBurst            = flag.Int("event-burst", DefaultEventBurst, "Number of events recorded per object before --event-qps applies")
+	previewTokenFile      = flag.String("preview-token-file", "", "Path to a bearer token enabling the resolv.conf preview endpoint on the operations listener for clients presenting it, disabled if empty")
+	leaseName             = flag.String("lease-name", "nodelocaldns-webhook", "Name of the Lease used to elect the replica that writes cluster objects")
+)
+
+// Subcommands run instead of the webhook server when named by the first argument
+const (
+	RenderCommand      = "render"
+	KRMFunctionCommand = "krm"
+	ResolvConfCommand  = "resolvconf"
 )
 
+// subcommands are the commands the binary runs besides the webhook server, each
+// returning the exit status
+var subcommands = map[string]func(args []string, stdin io.Reader, stdout, stderr io.Writer) int{
+	RenderCommand:      runRender,
+	KRMFunctionCommand: runKRMFunction,
+	ResolvConfCommand:  runResolvConf,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+
 	flag.Parse()
 
 	logconf := textlogger.NewConfig(textlogger.Verbosity(*logVerbosity))
 	logger := textlogger.NewLogger(logconf)
 	logger.Info("nodelocaldns-admission-controller",
+		"version", Version,
 		"cert-file", *certFile,
 		"key-file", *keyFile,
 		"port", *port,
+		"ops-port", *opsPort,
 		"log-verbosity", *logVerbosity,
+		"config", *configFile,
+		"self-signed-certs", *selfSignedCerts,
+		"manage-webhook-config", *manageWebhookConfig,
+		"client-ca-file", *clientCAFile,
+		"tls-min-version", *tlsMinVersion,
+		"http2", *http2,
+		"max-in-flight", *maxInFlight,
 	)
 
-	// Set up context for graceful shutdown
+	// Set up context for background tasks, cancelled once the webhook server stopped
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
-	sigChan := make(chan os.Signal, 1)
+	// Handle shutdown signals, a second signal skips the drain period
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// A signal during startup cancels the calls in progress, afterwards the drain logic handles signals
+	started := make(chan struct{})
 	go func() {
-		sig := <-sigChan
-		logger.Info("Shutdown signal received", "signal", sig.String())
-		cancel()
+		select {
+		case sig := <-sigChan:
+			logger.Info("Shutdown signal received during startup", "signal", sig.String())
+			cancel()
+		case <-started:
+		}
 	}()
 
+	// Start operations server, readiness fails until every check passes
+	readiness := NewReadiness()
+	var opsServer *OpsServer
+	if *opsPort != 0 {
+		opsServer = NewOpsServer(logger, *opsPort, readiness)
+		if err := opsServer.Start(); err != nil {
+			logger.Error(err, "Failed to start operations server")
+			os.Exit(1)
+		}
+	}
+
 	cfg, err := rest.InClusterConfig()
 	if err != nil {
 		logger.Error(err, "Failed to create kubernetes config")
@@ -56,42 +150,225 @@ func main() {
 		logger.Error(err, "Failed to create Kubernetes client")
 		os.Exit(1)
 	}
-	service, err := client.CoreV1().Services("kube-system").Get(ctx, "kube-dns", metav1.GetOptions{})
-	if err != nil {
-		logger.Error(err, "Failed to discover cluster DNS")
+
+	// Set up the serving certificate source
+	var certs CertificateSource
+	if *selfSignedCerts {
+		certs = NewSecretCertSource(logger, client, *namespace, *certSecretName, readiness)
+	} else {
+		certs, err = NewCertWatcher(logger, *certFile, *keyFile)
+		if err != nil {
+			logger.Error(err, "Certificate validation failed")
+			os.Exit(1)
+		}
+	}
+
+	if *overloadDecision != OverloadAllow && *overloadDecision != OverloadDeny {
+		logger.Error(fmt.Errorf("must be %s or %s, got %q", OverloadAllow, OverloadDeny, *overloadDecision), "Invalid overload decision")
 		os.Exit(1)
 	}
-	clusterDNSIP := service.Spec.ClusterIP
 
-	// Load configuration with discovered DNS IP
-	webhookConfig, err := LoadConfig(clusterDNSIP)
+	// Parse the TLS policy, rejecting insecure combinations
+	tlsPolicy, err := ParseTLSPolicy(*tlsMinVersion, *tlsMaxVersion, *tlsCipherSuites, *tlsCurvePreferences, *http2)
 	if err != nil {
-		logger.Error(err, "Failed to load configuration")
+		logger.Error(err, "Invalid TLS policy")
 		os.Exit(1)
 	}
 
+	// Set up client certificate verification
+	var clientAuth *ClientAuth
+	if *clientCAFile != "" {
+		clientAuth, err = NewClientAuth(logger, *clientCAFile, parseList(*clientAllowedNames))
+		if err != nil {
+			logger.Error(err, "Failed to set up client certificate verification")
+			os.Exit(1)
+		}
+	}
+
+	// Watch namespaces, which select DNS profiles through their labels and annotations
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	readiness.AddCacheSync(CheckNamespaces, namespaceInformer.Informer().HasSynced)
+	informerFactory.Start(ctx.Done())
+
+	// Set up the decision log
+	var decisionLog *DecisionLog
+	if *decisionLogSink != "" {
+		decisionLog, err = NewDecisionLog(ctx, logger, DecisionLogOptions{
+			Sink:         *decisionLogSink,
+			File:         *decisionLogFile,
+			MaxSize:      *decisionLogMaxSize,
+			MaxBackups:   *decisionLogMaxBackups,
+			OTLPEndpoint: *decisionLogEndpoint,
+			SampleRate:   *decisionLogSampleRate,
+			Redact:       parseList(*decisionLogRedact),
+		})
+		if err != nil {
+			logger.Error(err, "Failed to set up decision log")
+			os.Exit(1)
+		}
+	}
+
+	// Set up tracing
+	var tracerProvider *sdktrace.TracerProvider
+	if *tracing {
+		tracerProvider, err = NewTracerProvider(ctx, *tracingEndpoint, *tracingSampleRatio)
+		if err != nil {
+			logger.Error(err, "Failed to set up tracing")
+			os.Exit(1)
+		}
+	}
+
+	// Set up the event recorder
+	var eventBroadcaster record.EventBroadcaster
+	var eventRecorder record.EventRecorder
+	if *events {
+		eventBroadcaster, eventRecorder = NewEventBroadcaster(ctx, client, float32(*eventQPS), *eventBurst)
+	}
+
+	// Read the token clients of the resolv.conf preview endpoint present
+	var previewToken string
+	if *previewTokenFile != "" {
+		data, err := os.ReadFile(*previewTokenFile)
+		if err != nil {
+			logger.Error(err, "Failed to read preview token")
+			os.Exit(1)
+		}
+		if previewToken = strings.TrimSpace(string(data)); previewToken == "" {
+			logger.Error(fmt.Errorf("%s is empty", *previewTokenFile), "Invalid preview token")
+			os.Exit(1)
+		}
+		if opsServer == nil {
+			logger.Error(fmt.Errorf("--ops-port is 0"), "The preview endpoint requires the operations listener")
+			os.Exit(1)
+		}
+	}
+
 	// Create webhook server
-	server, err := NewServer(logger, *port, *certFile, *keyFile, webhookConfig)
+	serverOpts := ServerOptions{
+		Port:                *port,
+		Certs:               certs,
+		TLSPolicy:           tlsPolicy,
+		ClientAuth:          clientAuth,
+		MaxRequestBytes:     *maxRequestBytes,
+		ShutdownGracePeriod: *shutdownGracePeriod,
+		MaxInFlight:         *maxInFlight,
+		MaxQueued:           *maxQueued,
+		QueueTimeout:        *queueTimeout,
+		OverloadDecision:    *overloadDecision,
+		Namespaces:          namespaceInformer.Lister(),
+		DecisionLog:         decisionLog,
+		Events:              eventRecorder,
+		PreviewToken:        previewToken,
+	}
+	if tracerProvider != nil {
+		serverOpts.TracerProvider = tracerProvider
+	}
+	server, err := NewServer(logger, serverOpts, readiness)
 	if err != nil {
 		logger.Error(err, "Failed to create webhook server")
 		os.Exit(1)
 	}
+	if previewToken != "" {
+		opsServer.Handle(PreviewPath, server.PreviewHandler())
+	}
 
-	// Start webhook server
+	// Start webhook server, it is not ready until the configuration is loaded
 	if err := server.Start(ctx); err != nil {
 		logger.Error(err, "Failed to start webhook server")
 		os.Exit(1)
 	}
 
+	service, err := client.CoreV1().Services("kube-system").Get(ctx, "kube-dns", metav1.GetOptions{})
+	if err != nil {
+		logger.Error(err, "Failed to discover cluster DNS")
+		os.Exit(1)
+	}
+	clusterDNSIP := service.Spec.ClusterIP
+
+	// Load configuration with discovered DNS IP
+	webhookConfig, err := LoadConfig(*configFile, clusterDNSIP)
+	if err != nil {
+		logger.Error(err, "Failed to load configuration")
+		os.Exit(1)
+	}
+	server.SetConfig(webhookConfig)
+	if err := server.WatchHostResolvConf(ctx); err != nil {
+		logger.Error(err, "Failed to watch host resolv.conf")
+		os.Exit(1)
+	}
+
+	// Start the tasks of the elected leader
+	var leaderRuns []func(context.Context)
+	registrarCAFile := *caFile
+	if *selfSignedCerts {
+		certManager := NewSelfSignedCertManager(logger, client, *namespace, *certSecretName, *serviceName,
+			webhookConfig.ClusterDomain, *webhookConfigName)
+		leaderRuns = append(leaderRuns, certManager.Run)
+		// The certificate manager owns the caBundle
+		registrarCAFile = ""
+	}
+	if *manageWebhookConfig {
+		registrar := NewWebhookRegistrar(logger, client, *webhookConfigName, *namespace, *serviceName,
+			int32(*servicePort), webhookConfig, registrarCAFile)
+		leaderRuns = append(leaderRuns, registrar.Run)
+	}
+	if len(leaderRuns) > 0 {
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.Error(err, "Failed to get hostname for leader election")
+			os.Exit(1)
+		}
+		StartLeaderElection(ctx, logger, client, *namespace, *leaseName, identity, leaderRuns...)
+	}
+
 	logger.Info("Webhook server started", "port", *port)
+	close(started)
 
-	// Wait for shutdown signal
-	<-ctx.Done()
+	// Wait for shutdown signal, unless one cancelled startup as it completed
+	select {
+	case sig := <-sigChan:
+		logger.Info("Shutdown signal received", "signal", sig.String())
+	case <-ctx.Done():
+	}
+
+	// Fail readiness first and keep serving while the API server stops routing to this replica
+	server.Drain()
+	select {
+	case <-time.After(*shutdownDrainPeriod):
+	case sig := <-sigChan:
+		logger.Info("Second shutdown signal received, skipping drain", "signal", sig.String())
+	}
 
 	// Stop webhook server
 	if err := server.Stop(context.Background()); err != nil {
 		logger.Error(err, "Failed to stop webhook server")
 	}
+	// Flush the decision records of the requests served
+	if decisionLog != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), *shutdownGracePeriod)
+		if err := decisionLog.Close(flushCtx); err != nil {
+			logger.Error(err, "Failed to flush decision log")
+		}
+		flushCancel()
+	}
+	if tracerProvider != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), *shutdownGracePeriod)
+		if err := tracerProvider.Shutdown(flushCtx); err != nil {
+			logger.Error(err, "Failed to flush traces")
+		}
+		flushCancel()
+	}
+	// Stop recording events, those of the requests served are queued already
+	if eventBroadcaster != nil {
+		eventBroadcaster.Shutdown()
+	}
+	cancel()
+	if opsServer != nil {
+		if err := opsServer.Stop(context.Background()); err != nil {
+			logger.Error(err, "Failed to stop operations server")
+		}
+	}
 
 	logger.Info("Webhook server shutdown complete")
 }
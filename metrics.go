@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/x509"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const metricsNamespace = "nodelocaldns_webhook"
+
+// Admission decisions
+const (
+	DecisionInjected = "injected"
+	DecisionSkipped  = "skipped"
+	DecisionDenied   = "denied"
+)
+
+// Reason codes explaining an admission decision
+const (
+	ReasonInjected          = "Injected"
+	ReasonAlreadyInjected   = "AlreadyInjected"
+	ReasonReconciled        = "Reconciled"
+	ReasonConfigNotLoaded   = "ConfigNotLoaded"
+	ReasonOverloaded        = "Overloaded"
+	ReasonNotPod            = "NotPod"
+	ReasonOperationIgnored  = "OperationIgnored"
+	ReasonUpdateNoop        = "UpdateNoop"
+	ReasonExistingDNSConfig = "ExistingDNSConfig"
+	ReasonDNSPolicyNone     = "DNSPolicyNone"
+	ReasonDNSPolicyDefault  = "DNSPolicyDefault"
+	ReasonHostNetwork       = "HostNetwork"
+	ReasonUnknownProfile    = "UnknownProfile"
+	ReasonDecodeError       = "DecodeError"
+	ReasonPatchError        = "PatchError"
+)
+
+// Error classes used by the errors counter
+const (
+	ErrorClassMethod         = "method"
+	ErrorClassContentType    = "content_type"
+	ErrorClassReadBody       = "read_body"
+	ErrorClassDecode         = "decode"
+	ErrorClassInvalidRequest = "invalid_request"
+	ErrorClassPanic          = "panic"
+	ErrorClassServe          = "serve"
+	ErrorClassPatch          = "patch"
+	ErrorClassEncode         = "encode"
+	ErrorClassWrite          = "write"
+	ErrorClassCertificate    = "certificate"
+	ErrorClassRegistration   = "registration"
+	ErrorClassConfig         = "config"
+)
+
+var (
+	// metricsRegistry holds every metric exposed by the webhook
+	metricsRegistry = prometheus.NewRegistry()
+
+	admissionRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Number of admission requests by operation, decision and reason code.",
+	}, []string{"operation", "decision", "reason"})
+
+	admissionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Time taken to handle an admission request.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+
+	admissionInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_in_flight_requests",
+		Help:      "Number of admission requests being handled.",
+	})
+
+	admissionQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_queue_depth",
+		Help:      "Number of admission requests waiting for a slot.",
+	})
+
+	admissionShedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_shed_total",
+		Help:      "Number of admission requests shed under overload by overload decision.",
+	}, []string{"decision"})
+
+	patchCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_cache_requests_total",
+		Help:      "Number of patch cache lookups by result, hit or miss.",
+	}, []string{"result"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors by error class.",
+	}, []string{"class"})
+
+	configGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_generation",
+		Help:      "Generation of the configuration currently in use.",
+	})
+
+	clientRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "client_rejections_total",
+		Help:      "Number of TLS clients rejected by client certificate verification by reason.",
+	}, []string{"reason"})
+
+	decisionLogRecordsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decision_log_records_total",
+		Help:      "Number of decision records by result: written, sampled_out, dropped or error.",
+	}, []string{"result"})
+
+	certificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "NotAfter of the serving certificate as a Unix timestamp.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		admissionRequestsTotal,
+		admissionDuration,
+		admissionInFlight,
+		admissionQueueDepth,
+		admissionShedTotal,
+		patchCacheRequestsTotal,
+		errorsTotal,
+		configGeneration,
+		certificateExpiry,
+		clientRejectionsTotal,
+		decisionLogRecordsTotal,
+	)
+}
+
+// recordAdmission counts an admission decision
+func recordAdmission(operation admissionv1.Operation, decision, reason string) {
+	admissionRequestsTotal.WithLabelValues(string(operation), decision, reason).Inc()
+}
+
+// recordError counts an error of the given class
+func recordError(class string) {
+	errorsTotal.WithLabelValues(class).Inc()
+}
+
+// recordCertificateExpiry exposes the NotAfter of the serving certificate
+func recordCertificateExpiry(cert *x509.Certificate) {
+	certificateExpiry.Set(float64(cert.NotAfter.Unix()))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmissionMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		noConfig     bool
+		operation    admissionv1.Operation
+		resource     string
+		pod          string
+		wantDecision string
+		wantReason   string
+	}{
+		{name: "injected", pod: `{"metadata":{"name":"web"}}`, wantDecision: DecisionInjected, wantReason: ReasonInjected},
+		{name: "existing dnsConfig", pod: `{"metadata":{"name":"web"},"spec":{"dnsConfig":{}}}`, wantDecision: DecisionSkipped, wantReason: ReasonExistingDNSConfig},
+		{name: "dnsPolicy None", pod: `{"metadata":{"name":"web"},"spec":{"dnsPolicy":"None"}}`, wantDecision: DecisionSkipped, wantReason: ReasonDNSPolicyNone},
+		{name: "host network", pod: `{"metadata":{"name":"web"},"spec":{"hostNetwork":true}}`, wantDecision: DecisionSkipped, wantReason: ReasonHostNetwork},
+		{name: "unknown profile", pod: `{"metadata":{"annotations":{"nodelocaldns.io/profile":"missing"}}}`, wantDecision: DecisionDenied, wantReason: ReasonUnknownProfile},
+		{name: "undecodable pod", pod: `{"metadata":[]}`, wantDecision: DecisionDenied, wantReason: ReasonDecodeError},
+		{name: "update", operation: admissionv1.Update, pod: `{"metadata":{"name":"web"}}`, wantDecision: DecisionSkipped, wantReason: ReasonUpdateNoop},
+		{name: "delete", operation: admissionv1.Delete, pod: `{"metadata":{"name":"web"}}`, wantDecision: DecisionSkipped, wantReason: ReasonOperationIgnored},
+		{name: "not a pod", resource: "services", pod: `{"metadata":{"name":"web"}}`, wantDecision: DecisionSkipped, wantReason: ReasonNotPod},
+		{name: "configuration not loaded", noConfig: true, pod: `{"metadata":{"name":"web"}}`, wantDecision: DecisionSkipped, wantReason: ReasonConfigNotLoaded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			if tt.noConfig {
+				s.config.Store(nil)
+			}
+			req := benchmarkRequest(t)
+			req.Object.Raw = []byte(tt.pod)
+			if tt.operation != "" {
+				req.Operation = tt.operation
+			}
+			if tt.resource != "" {
+				req.Resource = metav1.GroupVersionResource{Version: "v1", Resource: tt.resource}
+			}
+
+			counter := admissionRequestsTotal.WithLabelValues(string(req.Operation), tt.wantDecision, tt.wantReason)
+			before := testutil.ToFloat64(counter)
+			s.processAdmissionRequest(context.Background(), req)
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("admission_requests_total{operation=%q,decision=%q,reason=%q} increased by %v, want 1",
+					req.Operation, tt.wantDecision, tt.wantReason, got)
+			}
+		})
+	}
+}
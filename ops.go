@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Operations listener paths
+	MetricsPath = "/metrics"
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// readinessCheck is a named check that returns an error while its component is not ready
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// Readiness aggregates the named readiness checks of the webhook
+type Readiness struct {
+	mu     sync.RWMutex
+	checks []readinessCheck
+}
+
+// NewReadiness creates an empty set of readiness checks
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Add registers a named readiness check
+func (r *Readiness) Add(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// AddCacheSync registers a readiness check that fails until the given informers have synced
+func (r *Readiness) AddCacheSync(name string, synced ...cache.InformerSynced) {
+	r.Add(name, func() error {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				return fmt.Errorf("caches not synced")
+			}
+		}
+		return nil
+	})
+}
+
+// Failed runs every check and returns the failure message of each failed check by name
+func (r *Readiness) Failed() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	failed := make(map[string]string)
+	for _, c := range r.checks {
+		if err := c.check(); err != nil {
+			failed[c.name] = err.Error()
+		}
+	}
+	return failed
+}
+
+// ServeHTTP reports readiness, naming every failed check in the response body
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	response := map[string]interface{}{
+		"status": "ready",
+		"time":   time.Now().UTC().Format(time.RFC3339),
+	}
+	statusCode := http.StatusOK
+	if failed := r.Failed(); len(failed) > 0 {
+		response["status"] = "not ready"
+		response["failed"] = failed
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// OpsServer serves metrics and health probes on a plain HTTP listener
+type OpsServer struct {
+	logger logr.Logger
+	server *http.Server
+	mux    *http.ServeMux
+	port   int
+	// listener is set once Start has bound it
+	listener net.Listener
+}
+
+// NewOpsServer creates a new operations server
+func NewOpsServer(logger logr.Logger, port int, readiness *Readiness) *OpsServer {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.HandleFunc(HealthzPath, handleHealth)
+	mux.Handle(ReadyzPath, readiness)
+
+	return &OpsServer{
+		logger: logger,
+		port:   port,
+		mux:    mux,
+		server: &http.Server{
+			Addr:         ":" + strconv.Itoa(port),
+			Handler:      mux,
+			ReadTimeout:  ReadTimeout,
+			WriteTimeout: WriteTimeout,
+			IdleTimeout:  IdleTimeout,
+		},
+	}
+}
+
+// Handle serves an additional endpoint on the operations listener, also once started
+func (o *OpsServer) Handle(pattern string, handler http.Handler) {
+	o.mux.Handle(pattern, handler)
+}
+
+// Start binds the listener and begins serving the operations endpoints in the background,
+// returning once the listener is bound or failed to bind
+func (o *OpsServer) Start() error {
+	o.logger.Info("Starting operations server", "port", o.port)
+
+	// Bind synchronously so that probes do not silently go unanswered
+	listener, err := net.Listen("tcp", o.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", o.server.Addr, err)
+	}
+	o.listener = listener
+
+	go func() {
+		if err := o.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			o.logger.Error(err, "Operations server failed")
+		}
+	}()
+	return nil
+}
+
+// Stop shuts down the operations server
+func (o *OpsServer) Stop(ctx context.Context) error {
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := o.server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shutdown operations server: %w", err)
+	}
+	return nil
+}
+
+// handleHealth handles liveness check requests
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+
+	response := map[string]string{
+		"status": "healthy",
+		"time":   time.Now().UTC().Format(time.RFC3339),
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestOpsServer(t *testing.T) {
+	var ready atomic.Bool
+	readiness := NewReadiness()
+	readiness.Add("config", func() error {
+		if !ready.Load() {
+			return errors.New("configuration not loaded")
+		}
+		return nil
+	})
+
+	o := NewOpsServer(logr.Discard(), 0, readiness)
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop(context.Background())
+	base := "http://" + o.listener.Addr().String()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get(HealthzPath); code != http.StatusOK {
+		t.Errorf("%s = %d, want %d", HealthzPath, code, http.StatusOK)
+	}
+
+	code, body := get(ReadyzPath)
+	var status struct {
+		Status string            `json:"status"`
+		Failed map[string]string `json:"failed"`
+	}
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusServiceUnavailable || status.Failed["config"] != "configuration not loaded" {
+		t.Errorf("%s = %d %s, want the failed check named", ReadyzPath, code, body)
+	}
+	ready.Store(true)
+	if code, body := get(ReadyzPath); code != http.StatusOK {
+		t.Errorf("%s = %d %s, want %d", ReadyzPath, code, body, http.StatusOK)
+	}
+
+	recordAdmission("CREATE", DecisionInjected, ReasonInjected)
+	if code, body := get(MetricsPath); code != http.StatusOK || !strings.Contains(body, metricsNamespace+"_") {
+		t.Errorf("%s = %d, want the webhook metrics", MetricsPath, code)
+	}
+}
+
+func TestOpsServerBindFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	o := NewOpsServer(logr.Discard(), listener.Addr().(*net.TCPAddr).Port, NewReadiness())
+	if err := o.Start(); err == nil {
+		o.Stop(context.Background())
+		t.Fatal("Start succeeded on a port in use")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+)
+
+// maxCachedPatches bounds the patch cache, which is reset when full
+const maxCachedPatches = 4096
+
+// cachedPatch is a JSON patch setting the DNS settings of a pod along with the warnings
+// about search domains it dropped
+type cachedPatch struct {
+	patch    []byte
+	warnings []string
+	// truncated is the warning about the search domains dropped beyond the search path
+	// limits, empty if none
+	truncated string
+	// hash identifies the DNS settings the patch writes
+	hash string
+	// dnsConfig and replacesPolicy are what the pod's DNS settings look like once patched
+	dnsConfig      *corev1.PodDNSConfig
+	replacesPolicy bool
+}
+
+// matches reports whether the pod's DNS settings are those the patch sets
+func (c *cachedPatch) matches(spec *podDNSSpec) bool {
+	if c.replacesPolicy && spec.DNSPolicy != corev1.DNSNone {
+		return false
+	}
+	return apiequality.Semantic.DeepEqual(spec.DNSConfig, c.dnsConfig)
+}
+
+// patchCache holds the compiled search domain templates per profile and the precomputed
+// JSON patch per namespace and profile for the configuration in use, and is invalidated
+// when the configuration changes. The zero value is ready to use.
+type patchCache struct {
+	mu        sync.RWMutex
+	config    *Config
+	templates map[string]*searchTemplates
+	patches   map[string]*cachedPatch
+}
+
+// get returns the JSON patch for a pod selecting the profile, building it on a miss
+func (c *patchCache) get(cfg *Config, profile string, vars *searchVars) (*cachedPatch, error) {
+	templates, err := c.searchTemplates(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Profile names are label values, which cannot contain a slash
+	key := profile + "/" + vars.Namespace
+	var searches, warnings []string
+	var truncated string
+	if templates.labels {
+		// Domains rendered from labels vary per pod, so key the patch on the result
+		searches, warnings, truncated = templates.render(vars, cfg.HostSearches)
+		key += "/" + strings.Join(searches, ",")
+	}
+
+	c.mu.RLock()
+	cached, ok := c.patches[key]
+	current := c.config == cfg
+	c.mu.RUnlock()
+	if ok && current {
+		patchCacheRequestsTotal.WithLabelValues("hit").Inc()
+		if templates.labels && cached.replacesPolicy {
+			withWarnings := *cached
+			withWarnings.warnings = warnings
+			withWarnings.truncated = truncated
+			return &withWarnings, nil
+		}
+		return cached, nil
+	}
+	patchCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	if !templates.labels {
+		searches, warnings, truncated = templates.render(vars, cfg.HostSearches)
+	}
+	dnsConfig := profileDNSConfig(cfg, profile)
+	if dnsConfig.replacesPolicy() {
+		dnsConfig.Searches = searches
+	} else {
+		// Kubelet keeps managing the search path
+		warnings, truncated = nil, ""
+	}
+	podDNSConfig := dnsConfig.podDNSConfig()
+	hash, err := dnsSettingsHash(dnsConfig.replacesPolicy(), podDNSConfig)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := generateJSONPatch(dnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	cached = &cachedPatch{
+		patch:          patch,
+		warnings:       warnings,
+		truncated:      truncated,
+		hash:           hash,
+		dnsConfig:      podDNSConfig,
+		replacesPolicy: dnsConfig.replacesPolicy(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetLocked(cfg)
+	if len(c.patches) >= maxCachedPatches {
+		c.patches = make(map[string]*cachedPatch)
+	}
+	c.patches[key] = cached
+	return cached, nil
+}
+
+// searchTemplates returns the compiled search domain templates of the profile
+func (c *patchCache) searchTemplates(cfg *Config, profile string) (*searchTemplates, error) {
+	c.mu.RLock()
+	templates, ok := c.templates[profile]
+	current := c.config == cfg
+	c.mu.RUnlock()
+	if ok && current {
+		return templates, nil
+	}
+
+	// Templates were validated when the configuration was loaded
+	templates, err := compileSearchTemplates(profileDNSConfig(cfg, profile).Searches)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetLocked(cfg)
+	c.templates[profile] = templates
+	return templates, nil
+}
+
+// resetLocked empties the cache if it holds entries of another configuration, c.mu must be held
+func (c *patchCache) resetLocked(cfg *Config) {
+	if c.config == cfg && c.patches != nil {
+		return
+	}
+	c.config = cfg
+	c.templates = make(map[string]*searchTemplates)
+	c.patches = make(map[string]*cachedPatch)
+}
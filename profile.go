@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ProfileAnnotation selects the DNS profile of a pod or namespace
+const ProfileAnnotation = "nodelocaldns.io/profile"
+
+// Injection label values that do not name a DNS profile, "true" is an alias of "enabled"
+// selecting the default profile
+const (
+	InjectionEnabled  = "enabled"
+	InjectionTrue     = "true"
+	InjectionDisabled = "disabled"
+)
+
+// DefaultProfile is the profile of pods that select none, the base configuration
+// is used if it is not defined
+const DefaultProfile = "default"
+
+// selectedProfile returns the profile named by the profile annotation or, failing
+// that, by the injection label value, empty if none is named
+func selectedProfile(injectionLabel string, labels, annotations map[string]string) string {
+	if name := annotations[ProfileAnnotation]; name != "" {
+		return name
+	}
+	switch value := labels[injectionLabel]; value {
+	case "", InjectionEnabled, InjectionTrue, InjectionDisabled:
+		return ""
+	default:
+		return value
+	}
+}
+
+// resolveProfile returns the name of the profile selected by the pod, or else by its
+// namespace if known, DefaultProfile if neither selects one. It fails if the profile is
+// not defined.
+func resolveProfile(cfg *Config, pod *podMetadata, ns *corev1.Namespace) (string, error) {
+	name := selectedProfile(cfg.Webhook.InjectionLabel, pod.Labels, pod.Annotations)
+	if name == "" && ns != nil {
+		name = selectedProfile(cfg.Webhook.InjectionLabel, ns.Labels, ns.Annotations)
+	}
+	if name == "" || name == DefaultProfile {
+		return DefaultProfile, nil
+	}
+	if _, ok := cfg.Profiles[name]; !ok {
+		return "", fmt.Errorf("unknown DNS profile %q", name)
+	}
+	return name, nil
+}
+
+// lookupNamespace returns the namespace from the cache, nil if namespaces are not
+// watched or it is missing
+func (s *Server) lookupNamespace(name string) *corev1.Namespace {
+	if s.namespaces == nil || name == "" {
+		return nil
+	}
+	ns, err := s.namespaces.Get(name)
+	if err != nil {
+		return nil
+	}
+	return ns
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Provenance annotations recording what the webhook did to a pod and with which configuration
+const (
+	DecisionAnnotation        = "nodelocaldns.io/decision"
+	ReasonAnnotation          = "nodelocaldns.io/reason"
+	InjectedProfileAnnotation = "nodelocaldns.io/injected-profile"
+	VersionAnnotation         = "nodelocaldns.io/version"
+	ConfigHashAnnotation      = "nodelocaldns.io/config-hash"
+)
+
+// provenancePatch returns the JSON patch operations recording the admission decision
+// on the pod, only for the annotations and labels it does not carry yet. The profile
+// and configuration hash are only recorded for injected pods.
+func provenancePatch(cfg *Config, pod *podMetadata, decision, reason, profile, hash string) []jsonPatchOperation {
+	annotations := map[string]string{
+		DecisionAnnotation: decision,
+		ReasonAnnotation:   reason,
+		VersionAnnotation:  Version,
+	}
+	if profile != "" {
+		annotations[InjectedProfileAnnotation] = profile
+	}
+	if hash != "" {
+		annotations[ConfigHashAnnotation] = hash
+	}
+	operations := metadataPatch("annotations", pod.Annotations, annotations)
+
+	// The label lets NetworkPolicies and monitoring select injected pods
+	if decision == DecisionInjected && cfg.InjectedLabel != "" {
+		operations = append(operations, metadataPatch("labels", pod.Labels, map[string]string{cfg.InjectedLabel: "true"})...)
+	}
+	return operations
+}
+
+// metadataPatch returns the JSON patch operations setting entries of a metadata map,
+// adding the whole map if the pod has none
+func metadataPatch(field string, current, desired map[string]string) []jsonPatchOperation {
+	if current == nil {
+		return []jsonPatchOperation{{Op: "add", Path: "/metadata/" + field, Value: desired}}
+	}
+
+	keys := make([]string, 0, len(desired))
+	for key, value := range desired {
+		if existing, ok := current[key]; !ok || existing != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	operations := make([]jsonPatchOperation, 0, len(keys))
+	for _, key := range keys {
+		operations = append(operations, jsonPatchOperation{
+			Op:    "add",
+			Path:  "/metadata/" + field + "/" + escapeJSONPointer(key),
+			Value: desired[key],
+		})
+	}
+	return operations
+}
+
+// appendJSONPatch appends operations to a marshalled JSON patch, which may be empty
+func appendJSONPatch(patch []byte, operations []jsonPatchOperation) ([]byte, error) {
+	if len(operations) == 0 {
+		return patch, nil
+	}
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON patch: %w", err)
+	}
+	if len(patch) == 0 {
+		return data, nil
+	}
+
+	// Join the arrays, dropping the closing bracket of the first and the opening one of the second
+	joined := make([]byte, 0, len(patch)+len(data))
+	joined = append(joined, patch[:len(patch)-1]...)
+	joined = append(joined, ',')
+	return append(joined, data[1:]...), nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// WebhookResyncInterval is how often the webhook configuration is checked for drift
+	// in addition to reacting to its changes
+	WebhookResyncInterval = 5 * time.Minute
+
+	// WebhookName is the name of the webhook within the configuration
+	WebhookName = "nodelocaldns-admission-controller.k8s.io"
+
+	// ManagedByLabel marks the webhook configuration as owned by this binary
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "nodelocaldns-admission-controller"
+)
+
+// WebhookRegistrar creates the MutatingWebhookConfiguration from config and repairs
+// any drift from it. Only the elected leader runs it.
+type WebhookRegistrar struct {
+	logger      logr.Logger
+	client      kubernetes.Interface
+	name        string
+	namespace   string
+	serviceName string
+	servicePort int32
+	config      *Config
+	// caFile is the CA bundle to trust; when empty the live caBundle is preserved
+	caFile string
+}
+
+// NewWebhookRegistrar creates a new webhook registrar
+func NewWebhookRegistrar(logger logr.Logger, client kubernetes.Interface, name, namespace, serviceName string, servicePort int32, cfg *Config, caFile string) *WebhookRegistrar {
+	return &WebhookRegistrar{
+		logger:      logger,
+		client:      client,
+		name:        name,
+		namespace:   namespace,
+		serviceName: serviceName,
+		servicePort: servicePort,
+		config:      cfg,
+		caFile:      caFile,
+	}
+}
+
+// Run reconciles the webhook configuration whenever it changes and periodically,
+// until the context is cancelled
+func (r *WebhookRegistrar) Run(ctx context.Context) {
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, WebhookResyncInterval,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", r.name).String()
+		}),
+	)
+	informer := factory.Admissionregistration().V1().MutatingWebhookConfigurations().Informer()
+
+	// Coalesce change notifications into a single pending reconcile
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		}
+
+		if err := r.reconcile(ctx); err != nil {
+			recordError(ErrorClassRegistration)
+			r.logger.Error(err, "Failed to reconcile webhook configuration", "name", r.name)
+			// Retry after a short delay, unless another change arrives first
+			time.AfterFunc(CertRetryInterval, notify)
+		}
+	}
+}
+
+// reconcile creates the webhook configuration or updates it to match the desired state
+func (r *WebhookRegistrar) reconcile(ctx context.Context) error {
+	webhooks := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	live, err := webhooks.Get(ctx, r.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		desired, err := r.desired(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := webhooks.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create mutating webhook configuration %s: %w", r.name, err)
+		}
+		r.logger.Info("Created mutating webhook configuration", "name", r.name)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get mutating webhook configuration %s: %w", r.name, err)
+	}
+
+	desired, err := r.desired(live)
+	if err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(live.Webhooks, desired.Webhooks) &&
+		live.Labels[ManagedByLabel] == ManagedByValue {
+		return nil
+	}
+
+	if _, err := webhooks.Update(ctx, desired, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update mutating webhook configuration %s: %w", r.name, err)
+	}
+	r.logger.Info("Repaired mutating webhook configuration drift", "name", r.name)
+	return nil
+}
+
+// desired builds the webhook configuration from config, keeping the metadata of the
+// live object if any and its caBundle unless a CA file is configured
+func (r *WebhookRegistrar) desired(live *admissionregistrationv1.MutatingWebhookConfiguration) (*admissionregistrationv1.MutatingWebhookConfiguration, error) {
+	desired := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: r.name},
+	}
+	var caBundle []byte
+	if live != nil {
+		desired.ObjectMeta = *live.ObjectMeta.DeepCopy()
+		for _, webhook := range live.Webhooks {
+			if webhook.Name == WebhookName {
+				caBundle = webhook.ClientConfig.CABundle
+			}
+		}
+	}
+	if desired.Labels == nil {
+		desired.Labels = map[string]string{}
+	}
+	desired.Labels[ManagedByLabel] = ManagedByValue
+
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", r.caFile, err)
+		}
+		caBundle = data
+	}
+
+	desired.Webhooks = []admissionregistrationv1.MutatingWebhook{r.webhook(caBundle)}
+	return desired, nil
+}
+
+// webhook builds the webhook entry from the registration settings, setting every
+// field the API server would otherwise default so drift can be compared exactly
+func (r *WebhookRegistrar) webhook(caBundle []byte) admissionregistrationv1.MutatingWebhook {
+	registration := r.config.Webhook
+
+	// Namespaces opt in with "enabled", its alias "true" or the name of a DNS profile, other
+	// values are not matched
+	optIn := append([]string{InjectionEnabled, InjectionTrue}, slices.Sorted(maps.Keys(r.config.Profiles))...)
+	namespaceSelector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      registration.InjectionLabel,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   optIn,
+		}},
+	}
+	for _, key := range registration.NamespaceExcludedLabelKeys {
+		namespaceSelector.MatchExpressions = append(namespaceSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		})
+	}
+	if len(registration.ExcludedNamespaces) > 0 {
+		namespaceSelector.MatchExpressions = append(namespaceSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   registration.ExcludedNamespaces,
+		})
+	}
+
+	objectSelector := &metav1.LabelSelector{}
+	for _, key := range registration.ObjectExcludedLabelKeys {
+		objectSelector.MatchExpressions = append(objectSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		})
+	}
+	objectSelector.MatchExpressions = append(objectSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      registration.InjectionLabel,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   []string{InjectionDisabled},
+	})
+
+	operations := []admissionregistrationv1.OperationType{admissionregistrationv1.Create}
+	if registration.InterceptUpdate {
+		operations = append(operations, admissionregistrationv1.Update)
+	}
+
+	path := InjectPath
+	port := r.servicePort
+	failurePolicy := admissionregistrationv1.FailurePolicyType(registration.FailurePolicy)
+	matchPolicy := admissionregistrationv1.Equivalent
+	reinvocationPolicy := admissionregistrationv1.ReinvocationPolicyType(registration.ReinvocationPolicy)
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	scope := admissionregistrationv1.AllScopes
+	timeoutSeconds := registration.TimeoutSeconds
+
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    WebhookName,
+		AdmissionReviewVersions: []string{"v1"},
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Namespace: r.namespace,
+				Name:      r.serviceName,
+				Path:      &path,
+				Port:      &port,
+			},
+			CABundle: caBundle,
+		},
+		FailurePolicy:      &failurePolicy,
+		MatchPolicy:        &matchPolicy,
+		NamespaceSelector:  namespaceSelector,
+		ObjectSelector:     objectSelector,
+		ReinvocationPolicy: &reinvocationPolicy,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+				Scope:       &scope,
+			},
+		}},
+		SideEffects:    &sideEffects,
+		TimeoutSeconds: &timeoutSeconds,
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestRegistrar(client kubernetes.Interface, caFile string) *WebhookRegistrar {
+	return NewWebhookRegistrar(logr.Discard(), client, "nodelocaldns-admission-controller", "kube-system",
+		"nodelocaldns-webhook", 443, DefaultConfig(), caFile)
+}
+
+func TestWebhookRegistrarDesired(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, []byte("from file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	live := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nodelocaldns-admission-controller",
+			ResourceVersion: "7",
+			Labels:          map[string]string{"team": "dns"},
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         WebhookName,
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("live")},
+		}},
+	}
+
+	tests := []struct {
+		name         string
+		caFile       string
+		live         *admissionregistrationv1.MutatingWebhookConfiguration
+		wantCABundle string
+		wantErr      bool
+	}{
+		{name: "created from CA file", caFile: caFile, wantCABundle: "from file"},
+		{name: "created without CA file"},
+		{name: "live caBundle kept without CA file", live: live, wantCABundle: "live"},
+		{name: "CA file over live caBundle", caFile: caFile, live: live, wantCABundle: "from file"},
+		{name: "missing CA file", caFile: filepath.Join(t.TempDir(), "missing.crt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err := newTestRegistrar(nil, tt.caFile).desired(tt.live)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("desired succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(desired.Webhooks) != 1 || string(desired.Webhooks[0].ClientConfig.CABundle) != tt.wantCABundle {
+				t.Fatalf("webhooks = %+v, want one with caBundle %q", desired.Webhooks, tt.wantCABundle)
+			}
+			if desired.Name != "nodelocaldns-admission-controller" || desired.Labels[ManagedByLabel] != ManagedByValue {
+				t.Errorf("metadata = %+v, want the name and managed-by label", desired.ObjectMeta)
+			}
+			if tt.live != nil && (desired.ResourceVersion != "7" || desired.Labels["team"] != "dns") {
+				t.Errorf("metadata = %+v, want that of the live object", desired.ObjectMeta)
+			}
+		})
+	}
+	if _, ok := live.Labels[ManagedByLabel]; ok {
+		t.Error("desired modified the live object")
+	}
+}
+
+func TestWebhookRegistrarReconcile(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r := newTestRegistrar(client, "")
+	ctx := context.Background()
+	webhooks := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	// A missing configuration is created
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	created, err := webhooks.Get(ctx, "nodelocaldns-admission-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An up to date configuration is left alone
+	client.ClearActions()
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("got %s, want the configuration left alone", action.GetVerb())
+		}
+	}
+
+	// Drift is repaired, keeping the caBundle someone else set
+	drifted := created.DeepCopy()
+	ignore := admissionregistrationv1.Ignore
+	fail := admissionregistrationv1.Fail
+	drifted.Webhooks[0].FailurePolicy = &fail
+	drifted.Webhooks[0].ClientConfig.CABundle = []byte("injected")
+	delete(drifted.Labels, ManagedByLabel)
+	if _, err := webhooks.Update(ctx, drifted, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repaired, err := webhooks.Get(ctx, "nodelocaldns-admission-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := repaired.Webhooks[0]
+	if *webhook.FailurePolicy != ignore || string(webhook.ClientConfig.CABundle) != "injected" || repaired.Labels[ManagedByLabel] != ManagedByValue {
+		t.Errorf("failurePolicy = %s, caBundle = %q, labels = %v, want the drift repaired", *webhook.FailurePolicy, webhook.ClientConfig.CABundle, repaired.Labels)
+	}
+
+	// Failing to read the CA file fails the reconcile
+	if err := newTestRegistrar(client, filepath.Join(t.TempDir(), "missing.crt")).reconcile(ctx); err == nil {
+		t.Error("reconcile succeeded without the CA file")
+	}
+}
+
+func TestWebhookNamespaceSelector(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Profiles = map[string]DNSConfig{
+		"batch":            {Strategy: StrategyOptionsOnly},
+		"latency-critical": {Options: []DNSOption{{Name: "timeout", Value: "1"}}},
+	}
+	r := NewWebhookRegistrar(logr.Discard(), nil, "nodelocaldns-admission-controller", "kube-system", "nodelocaldns-webhook", 443, cfg, "")
+	selector, err := metav1.LabelSelectorAsSelector(r.webhook(nil).NamespaceSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{"node-local-dns-injection": "enabled"}, true},
+		{map[string]string{"node-local-dns-injection": "batch"}, true},
+		{map[string]string{"node-local-dns-injection": "latency-critical"}, true},
+		{map[string]string{"node-local-dns-injection": "disabled"}, false},
+		{map[string]string{"node-local-dns-injection": "true"}, true},
+		{map[string]string{"node-local-dns-injection": "off"}, false},
+		{map[string]string{}, false},
+		{map[string]string{"node-local-dns-injection": "enabled", "eci": "true"}, false},
+		{map[string]string{"node-local-dns-injection": "enabled", "kubernetes.io/metadata.name": "kube-system"}, false},
+	}
+	for _, tt := range tests {
+		if got := selector.Matches(labels.Set(tt.labels)); got != tt.want {
+			t.Errorf("namespace selector matches %v = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
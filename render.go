@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace/noop"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultRenderClusterDNSIP is the cluster DNS service IP assumed when rendering offline
+const DefaultRenderClusterDNSIP = "10.96.0.10"
+
+// podTemplatePaths are the fields holding the pod template of the workload kinds, the
+// pod itself is at the root of a Pod
+var podTemplatePaths = map[string][]string{
+	"Pod":                   nil,
+	"PodTemplate":           {"template"},
+	"ReplicationController": {"spec", "template"},
+	"ReplicaSet":            {"spec", "template"},
+	"Deployment":            {"spec", "template"},
+	"StatefulSet":           {"spec", "template"},
+	"DaemonSet":             {"spec", "template"},
+	"Job":                   {"spec", "template"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template"},
+}
+
+// newOfflineServer creates a server deciding on admission requests without serving them,
+// looking up namespaces in the lister when it is set
+func newOfflineServer(cfg *Config, namespaces corelisters.NamespaceLister) *Server {
+	s := &Server{
+		logger:     logr.Discard(),
+		namespaces: namespaces,
+		tracer:     noop.NewTracerProvider().Tracer(TracerName),
+	}
+	s.SetConfig(cfg)
+	return s
+}
+
+// manifestRenderer applies the admission decisions of the webhook to manifests
+type manifestRenderer struct {
+	server *Server
+	// namespace is the namespace of the objects that do not set one
+	namespace string
+}
+
+// renderedObject is a manifest along with the admission decision on its pod
+type renderedObject struct {
+	// object is the manifest, mutated when the pod was patched
+	object map[string]interface{}
+	// decision, reason and profile explain the decision as in the decision log
+	decision string
+	reason   string
+	profile  string
+	// message is the reason of a denial
+	message string
+	// warnings are those returned to the client
+	warnings []string
+	// changes are the patch operations applied, relative to the manifest
+	changes []jsonPatchOperation
+}
+
+// render decides on the pod of a manifest as if it was being created and applies the
+// patch, manifests without a pod are left as they are
+func (r *manifestRenderer) render(ctx context.Context, object map[string]interface{}) (*renderedObject, error) {
+	rendered := &renderedObject{object: object}
+	u := &unstructured.Unstructured{Object: object}
+	path, ok := podTemplatePaths[u.GetKind()]
+	if !ok {
+		rendered.decision, rendered.reason = DecisionSkipped, ReasonNotPod
+		return rendered, nil
+	}
+
+	// Pods of workloads are made of their template, pods always have metadata once created
+	pod := runtime.DeepCopyJSON(object)
+	if path != nil {
+		template, _, err := unstructured.NestedMap(object, path...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod template: %w", err)
+		}
+		pod = map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "spec": template["spec"]}
+		if metadata, ok := template["metadata"]; ok {
+			pod["metadata"] = metadata
+		}
+	}
+	if _, ok := pod["metadata"].(map[string]interface{}); !ok {
+		pod["metadata"] = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pod: %w", err)
+	}
+
+	namespace := u.GetNamespace()
+	if namespace == "" {
+		namespace = r.namespace
+	}
+	dryRun := true
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID("render"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+		DryRun:    &dryRun,
+	}
+	response, result := r.server.admit(ctx, req)
+	rendered.decision = decisionFor(response, result.reason)
+	rendered.reason = result.reason
+	rendered.profile = result.profile
+	rendered.warnings = response.Warnings
+	if response.Result != nil {
+		rendered.message = response.Result.Message
+	}
+	if len(response.Patch) == 0 {
+		return rendered, nil
+	}
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply patch: %w", err)
+	}
+	var mutated map[string]interface{}
+	if err := json.Unmarshal(patched, &mutated); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal patched pod: %w", err)
+	}
+	if err := json.Unmarshal(response.Patch, &rendered.changes); err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+
+	// Write the pod back to the manifest, with the paths of the changes relative to it
+	if path == nil {
+		rendered.object = mutated
+		return rendered, nil
+	}
+	prefix := "/" + strings.Join(path, "/")
+	for i := range rendered.changes {
+		rendered.changes[i].Path = prefix + rendered.changes[i].Path
+	}
+	for _, field := range []string{"metadata", "spec"} {
+		value, ok := mutated[field]
+		if !ok {
+			continue
+		}
+		if err := unstructured.SetNestedField(object, value, append(path, field)...); err != nil {
+			return nil, fmt.Errorf("failed to set pod template %s: %w", field, err)
+		}
+	}
+	return rendered, nil
+}
+
+// readManifests reads the objects of YAML or JSON documents, expanding lists
+func readManifests(r io.Reader) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		// Empty documents decode to nothing
+		if object == nil {
+			continue
+		}
+		if items, ok := object["items"].([]interface{}); ok && strings.HasSuffix(fmt.Sprint(object["kind"]), "List") {
+			for _, item := range items {
+				if item, ok := item.(map[string]interface{}); ok {
+					objects = append(objects, item)
+				}
+			}
+			continue
+		}
+		objects = append(objects, object)
+	}
+}
+
+// readManifestFiles reads the objects of the files, stdin if none or -
+func readManifestFiles(files []string, stdin io.Reader) ([]map[string]interface{}, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var objects []map[string]interface{}
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		read, err := readManifests(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		objects = append(objects, read...)
+	}
+	return objects, nil
+}
+
+// namespaceLister indexes the Namespace objects among manifests, whose labels and
+// annotations then select DNS profiles as they do in the cluster
+func namespaceLister(objects []map[string]interface{}) (corelisters.NamespaceLister, error) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, object := range objects {
+		if object["kind"] != "Namespace" || object["apiVersion"] != "v1" {
+			continue
+		}
+		ns := &corev1.Namespace{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, ns); err != nil {
+			return nil, fmt.Errorf("invalid namespace: %w", err)
+		}
+		if err := indexer.Add(ns); err != nil {
+			return nil, fmt.Errorf("failed to index namespace %s: %w", ns.Name, err)
+		}
+	}
+	return corelisters.NewNamespaceLister(indexer), nil
+}
+
+// describeObject names a manifest as kind namespace/name
+func describeObject(object map[string]interface{}, namespace string) string {
+	u := &unstructured.Unstructured{Object: object}
+	if u.GetNamespace() != "" {
+		namespace = u.GetNamespace()
+	}
+	name := u.GetName()
+	if name == "" {
+		name = u.GetGenerateName() + "*"
+	}
+	if u.GetKind() == "Namespace" || namespace == "" {
+		return u.GetKind() + " " + name
+	}
+	return u.GetKind() + " " + namespace + "/" + name
+}
+
+// runRender implements the render command, printing the manifests read from files or
+// stdin as the webhook would mutate them
+func runRender(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(RenderCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "Path to a YAML config file, environment variables take precedence as for the webhook")
+	clusterDNSIP := flags.String("cluster-dns-ip", DefaultRenderClusterDNSIP, "IP of the cluster DNS service, discovered by the webhook")
+	namespace := flags.String("namespace", metav1.NamespaceDefault, "Namespace of the manifests that do not set one")
+	diff := flags.Bool("diff", false, "Print the changes and decision of each manifest instead of the mutated manifests")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s [flags] [file ...]\n\n", os.Args[0], RenderCommand)
+		fmt.Fprintf(stderr, "Applies the DNS injection of the webhook to the Pods and workloads read from YAML or JSON\n")
+		fmt.Fprintf(stderr, "files, or stdin if none or -, exiting with status 2 if a pod is denied.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	cfg, err := LoadConfig(*configFile, *clusterDNSIP)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	objects, err := readManifestFiles(flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	namespaces, err := namespaceLister(objects)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to read namespaces: %v\n", err)
+		return 1
+	}
+	renderer := &manifestRenderer{server: newOfflineServer(cfg, namespaces), namespace: *namespace}
+
+	denied := 0
+	for i, object := range objects {
+		name := describeObject(object, *namespace)
+		rendered, err := renderer.render(context.Background(), object)
+		if err != nil {
+			fmt.Fprintf(stderr, "Failed to render %s: %v\n", name, err)
+			return 1
+		}
+		if rendered.decision == DecisionDenied {
+			denied++
+		}
+
+		if *diff {
+			if err := writeRenderDiff(stdout, name, rendered); err != nil {
+				fmt.Fprintf(stderr, "Failed to write changes of %s: %v\n", name, err)
+				return 1
+			}
+			continue
+		}
+		data, err := yaml.Marshal(rendered.object)
+		if err != nil {
+			fmt.Fprintf(stderr, "Failed to marshal %s: %v\n", name, err)
+			return 1
+		}
+		if i > 0 {
+			fmt.Fprintln(stdout, "---")
+		}
+		stdout.Write(data)
+	}
+
+	if denied > 0 {
+		fmt.Fprintf(stderr, "%d of %d manifests denied\n", denied, len(objects))
+		return 2
+	}
+	return 0
+}
+
+// writeRenderDiff writes the decision on a manifest followed by the changes made to it
+func writeRenderDiff(w io.Writer, name string, rendered *renderedObject) error {
+	line := fmt.Sprintf("%s: %s (%s)", name, rendered.decision, rendered.reason)
+	if rendered.profile != "" {
+		line += ", profile " + rendered.profile
+	}
+	if rendered.message != "" {
+		line += ": " + rendered.message
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	for _, change := range rendered.changes {
+		value, err := json.Marshal(change.Value)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "  %s %s %s\n", change.Op, change.Path, value); err != nil {
+			return err
+		}
+	}
+	for _, warning := range rendered.warnings {
+		if _, err := fmt.Fprintf(w, "  warning: %s\n", warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
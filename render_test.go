@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const renderManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: batch
+  annotations:
+    nodelocaldns.io/profile: ndots
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: batch
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: report
+            image: busybox
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: node-agent
+  namespace: shop
+spec:
+  hostNetwork: true
+  containers:
+  - name: agent
+    image: busybox
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+  annotations:
+    nodelocaldns.io/profile: missing
+spec:
+  containers:
+  - name: api
+    image: nginx
+`
+
+// runRenderTest runs the render command on the test manifests with a config file defining
+// the ndots profile
+func runRenderTest(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := "nodeLocalDNSAddress: 169.254.20.10\nprofiles:\n  ndots:\n    options:\n    - name: ndots\n      value: \"2\"\n"
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runRender(append([]string{"--config", configFile}, args...), strings.NewReader(renderManifests), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRender(t *testing.T) {
+	code, stdout, stderr := runRenderTest(t)
+	if code != 2 || !strings.Contains(stderr, "1 of 5 manifests denied") {
+		t.Errorf("exit status = %d with %q, want 2 for the denied pod", code, stderr)
+	}
+
+	documents := strings.Split(stdout, "\n---\n")
+	if len(documents) != 5 {
+		t.Fatalf("got %d manifests, want 5:\n%s", len(documents), stdout)
+	}
+	var deployment struct {
+		Spec struct {
+			Replicas int `json:"replicas"`
+			Template struct {
+				Metadata struct {
+					Labels      map[string]string `json:"labels"`
+					Annotations map[string]string `json:"annotations"`
+				} `json:"metadata"`
+				Spec struct {
+					DNSPolicy string `json:"dnsPolicy"`
+					DNSConfig struct {
+						Nameservers []string `json:"nameservers"`
+					} `json:"dnsConfig"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(documents[1]), &deployment); err != nil {
+		t.Fatal(err)
+	}
+	template := deployment.Spec.Template
+	if deployment.Spec.Replicas != 2 || template.Metadata.Labels["app"] != "web" {
+		t.Errorf("deployment fields were not kept: %s", documents[1])
+	}
+	if template.Spec.DNSPolicy != "None" || len(template.Spec.DNSConfig.Nameservers) == 0 || template.Spec.DNSConfig.Nameservers[0] != "169.254.20.10" {
+		t.Errorf("pod template was not injected: %s", documents[1])
+	}
+	if template.Metadata.Annotations[DecisionAnnotation] != DecisionInjected {
+		t.Errorf("pod template decision = %q, want %q", template.Metadata.Annotations[DecisionAnnotation], DecisionInjected)
+	}
+}
+
+func TestRenderDiff(t *testing.T) {
+	_, stdout, _ := runRenderTest(t, "--diff", "--namespace", "batch")
+
+	for _, want := range []string{
+		"Namespace batch: skipped (NotPod)\n",
+		"Deployment shop/web: injected (Injected), profile default\n  replace /spec/template/spec/dnsPolicy \"None\"\n  add /spec/template/spec/dnsConfig {",
+		"CronJob batch/report: injected (Injected), profile ndots\n",
+		"  add /spec/jobTemplate/spec/template/metadata/annotations {",
+		`{"name":"ndots","value":"2"}`,
+		"Pod shop/node-agent: skipped (HostNetwork)\n  add /metadata/annotations {",
+		`Pod batch/api: denied (UnknownProfile): unknown DNS profile "missing"` + "\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, stdout)
+		}
+	}
+
+	// Namespaces among the manifests select profiles
+	code, stdout, _ := runRenderTest(t, "--diff")
+	if code != 2 {
+		t.Errorf("exit status = %d, want 2", code)
+	}
+	if !strings.Contains(stdout, "Pod default/api: denied") {
+		t.Errorf("pod without a namespace is not rendered in the default one:\n%s", stdout)
+	}
+}
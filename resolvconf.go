@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// PreviewPath is the path of the resolv.conf preview endpoint on the operations listener
+const PreviewPath = "/preview"
+
+// kubeletClusterFirstOptions are the options kubelet sets for pods using the cluster DNS
+var kubeletClusterFirstOptions = []string{"ndots:5"}
+
+// resolvConf is the content of a resolv.conf file
+type resolvConf struct {
+	nameservers []string
+	searches    []string
+	options     []string
+}
+
+// readResolvConf reads a file in resolv.conf format
+func readResolvConf(path string) (*resolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open resolv.conf: %w", err)
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
+
+// parseResolvConf parses resolv.conf content, where the last search or domain line wins
+// as with the system resolver
+func parseResolvConf(r io.Reader) (*resolvConf, error) {
+	conf := &resolvConf{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		// Stop at trailing comments
+		values := fields[1:]
+		for i, value := range values {
+			if strings.HasPrefix(value, "#") || strings.HasPrefix(value, ";") {
+				values = values[:i]
+				break
+			}
+		}
+		switch fields[0] {
+		case "nameserver":
+			if len(values) > 0 {
+				conf.nameservers = append(conf.nameservers, values[0])
+			}
+		case "search", "domain":
+			conf.searches = nil
+			for _, domain := range values {
+				// The root domain adds nothing to a search path
+				if domain = strings.TrimSuffix(domain, "."); domain != "" {
+					conf.searches = append(conf.searches, domain)
+				}
+			}
+		case "options":
+			conf.options = append(conf.options, values...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read resolv.conf: %w", err)
+	}
+	return conf, nil
+}
+
+// String returns the content in the order container runtimes write it
+func (c *resolvConf) String() string {
+	var b strings.Builder
+	if len(c.searches) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(c.searches, " "))
+	}
+	for _, nameserver := range c.nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", nameserver)
+	}
+	if len(c.options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(c.options, " "))
+	}
+	return b.String()
+}
+
+// kubeletResolvConf returns the resolv.conf kubelet generates for a pod in the namespace
+// on a node with the host resolv.conf, along with the limit violations the API server
+// rejects the pod for or kubelet truncates
+func kubeletResolvConf(cfg *Config, spec *podDNSSpec, namespace string, host *resolvConf) (*resolvConf, []string) {
+	violations := validatePodDNS(spec)
+
+	// Pods on the host network only use the cluster DNS with ClusterFirstWithHostNet
+	policy := spec.DNSPolicy
+	if policy == "" {
+		policy = corev1.DNSClusterFirst
+	}
+	if policy == corev1.DNSClusterFirst && spec.HostNetwork {
+		policy = corev1.DNSDefault
+	}
+
+	conf := &resolvConf{}
+	switch policy {
+	case corev1.DNSClusterFirst, corev1.DNSClusterFirstWithHostNet:
+		conf.nameservers = []string{cfg.ClusterDNSAddress}
+		conf.searches = omitDuplicates(append([]string{
+			namespace + ".svc." + cfg.ClusterDomain,
+			"svc." + cfg.ClusterDomain,
+			cfg.ClusterDomain,
+		}, host.searches...))
+		conf.options = kubeletClusterFirstOptions
+	case corev1.DNSDefault:
+		conf.nameservers = host.nameservers
+		conf.searches = host.searches
+		conf.options = host.options
+	}
+
+	if dnsConfig := spec.DNSConfig; dnsConfig != nil {
+		conf.nameservers = omitDuplicates(append(append([]string(nil), conf.nameservers...), dnsConfig.Nameservers...))
+		conf.searches = omitDuplicates(append(append([]string(nil), conf.searches...), dnsConfig.Searches...))
+		conf.options = mergeDNSOptions(conf.options, dnsConfig.Options)
+	}
+
+	// Kubelet omits what is beyond the resolver limits
+	if len(conf.nameservers) > MaxNameservers {
+		violations = append(violations, fmt.Sprintf("kubelet omits nameservers %q beyond the limit of %d", conf.nameservers[MaxNameservers:], MaxNameservers))
+		conf.nameservers = conf.nameservers[:MaxNameservers]
+	}
+	searches := make([]string, 0, len(conf.searches))
+	for _, domain := range conf.searches {
+		// Some resolvers abort on longer search domains
+		if len(domain) > validation.DNS1123SubdomainMaxLength {
+			violations = append(violations, fmt.Sprintf("kubelet omits search domain %q longer than %d characters", domain, validation.DNS1123SubdomainMaxLength))
+			continue
+		}
+		searches = append(searches, domain)
+	}
+	var truncated string
+	conf.searches, truncated = limitSearches(searches)
+	if truncated != "" {
+		violations = append(violations, "kubelet "+truncated)
+	}
+	return conf, violations
+}
+
+// validatePodDNS returns the reasons the API server rejects the DNS settings of a pod for
+func validatePodDNS(spec *podDNSSpec) []string {
+	var violations []string
+	dnsConfig := spec.DNSConfig
+	if spec.DNSPolicy == corev1.DNSNone && (dnsConfig == nil || len(dnsConfig.Nameservers) == 0) {
+		violations = append(violations, "the API server rejects dnsPolicy None without nameservers")
+	}
+	if dnsConfig == nil {
+		return violations
+	}
+	if len(dnsConfig.Nameservers) > MaxNameservers {
+		violations = append(violations, fmt.Sprintf("the API server rejects more than %d nameservers", MaxNameservers))
+	}
+	if len(dnsConfig.Searches) > MaxSearchDomains || len(strings.Join(dnsConfig.Searches, " ")) > MaxSearchListChars {
+		violations = append(violations, fmt.Sprintf("the API server rejects more than %d search domains or %d characters", MaxSearchDomains, MaxSearchListChars))
+	}
+	for _, domain := range dnsConfig.Searches {
+		if err := validateSearchDomain(domain); err != nil {
+			violations = append(violations, "the API server rejects "+err.Error())
+		}
+	}
+	return violations
+}
+
+// omitDuplicates returns the strings without repetitions, in order
+func omitDuplicates(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// mergeDNSOptions merges the options of a pod DNS configuration into resolv.conf options,
+// the pod's replacing those with the same name. Kubelet orders the merged options
+// arbitrarily, here they keep their order with the new ones last.
+func mergeDNSOptions(existing []string, options []corev1.PodDNSConfigOption) []string {
+	var names []string
+	values := make(map[string]string, len(existing)+len(options))
+	set := func(name, value string) {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+	for _, option := range existing {
+		name, value, _ := strings.Cut(option, ":")
+		set(name, value)
+	}
+	for _, option := range options {
+		value := ""
+		if option.Value != nil {
+			value = *option.Value
+		}
+		set(option.Name, value)
+	}
+
+	merged := make([]string, 0, len(names))
+	for _, name := range names {
+		if values[name] != "" {
+			name += ":" + values[name]
+		}
+		merged = append(merged, name)
+	}
+	return merged
+}
+
+// resolvConfPreview is the resolv.conf of a pod once the webhook mutated it
+type resolvConfPreview struct {
+	rendered   *renderedObject
+	conf       *resolvConf
+	violations []string
+}
+
+// previewResolvConf applies the admission decision of the webhook to the pod of a manifest
+// and returns the resolv.conf kubelet generates for it, nil if the pod is denied
+func previewResolvConf(ctx context.Context, renderer *manifestRenderer, object map[string]interface{}, host *resolvConf) (*resolvConfPreview, error) {
+	path, ok := podTemplatePaths[(&unstructured.Unstructured{Object: object}).GetKind()]
+	if !ok {
+		return nil, fmt.Errorf("not a Pod or a workload with a pod template")
+	}
+	rendered, err := renderer.render(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+	preview := &resolvConfPreview{rendered: rendered}
+	if rendered.decision == DecisionDenied {
+		return preview, nil
+	}
+
+	podSpec, _, err := unstructured.NestedMap(rendered.object, append(path, "spec")...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod spec: %w", err)
+	}
+	data, err := json.Marshal(podSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pod spec: %w", err)
+	}
+	var spec podDNSSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid pod spec: %w", err)
+	}
+
+	namespace := (&unstructured.Unstructured{Object: object}).GetNamespace()
+	if namespace == "" {
+		namespace = renderer.namespace
+	}
+	preview.conf, preview.violations = kubeletResolvConf(renderer.server.Config(), &spec, namespace, host)
+	return preview, nil
+}
+
+// writeResolvConfPreview writes the resolv.conf of a preview, preceded by comments with
+// the decision and the limit violations
+func writeResolvConfPreview(w io.Writer, preview *resolvConfPreview) error {
+	rendered := preview.rendered
+	line := fmt.Sprintf("# nodelocaldns: %s (%s)", rendered.decision, rendered.reason)
+	if rendered.profile != "" {
+		line += ", profile " + rendered.profile
+	}
+	lines := []string{line}
+	for _, warning := range rendered.warnings {
+		lines = append(lines, "# warning: "+warning)
+	}
+	for _, violation := range preview.violations {
+		lines = append(lines, "# violation: "+violation)
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s", strings.Join(lines, "\n"), preview.conf)
+	return err
+}
+
+// runResolvConf implements the resolvconf command, printing the resolv.conf kubelet
+// generates for the pod read from a file or stdin once the webhook mutated it
+func runResolvConf(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(ResolvConfCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "Path to a YAML config file, environment variables take precedence as for the webhook")
+	clusterDNSIP := flags.String("cluster-dns-ip", DefaultRenderClusterDNSIP, "IP of the cluster DNS service, which kubelet is configured with")
+	namespace := flags.String("namespace", metav1.NamespaceDefault, "Namespace of the pod if it does not set one")
+	nodeResolvConf := flags.String("node-resolv-conf", "", "Path to the resolv.conf of the node, the host search domains of the config apply if empty")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s [flags] [file]\n\n", os.Args[0], ResolvConfCommand)
+		fmt.Fprintf(stderr, "Prints the resolv.conf kubelet generates for the Pod or workload read from a YAML or JSON\n")
+		fmt.Fprintf(stderr, "file, or stdin if none or -, once the webhook mutated it, exiting with status 2 if denied.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 1
+	}
+
+	cfg, err := LoadConfig(*configFile, *clusterDNSIP)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	host := &resolvConf{searches: cfg.HostSearches}
+	if *nodeResolvConf != "" {
+		if host, err = readResolvConf(*nodeResolvConf); err != nil {
+			fmt.Fprintf(stderr, "Failed to load node resolv.conf: %v\n", err)
+			return 1
+		}
+	}
+
+	objects, err := readManifestFiles(flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	if len(objects) != 1 {
+		fmt.Fprintf(stderr, "Expected a single Pod or workload, got %d objects\n", len(objects))
+		return 1
+	}
+
+	renderer := &manifestRenderer{server: newOfflineServer(cfg, nil), namespace: *namespace}
+	preview, err := previewResolvConf(context.Background(), renderer, objects[0], host)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to preview %s: %v\n", describeObject(objects[0], *namespace), err)
+		return 1
+	}
+	if preview.conf == nil {
+		fmt.Fprintf(stderr, "%s denied: %s\n", describeObject(objects[0], *namespace), preview.rendered.message)
+		return 2
+	}
+	if err := writeResolvConfPreview(stdout, preview); err != nil {
+		fmt.Fprintf(stderr, "Failed to write resolv.conf: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// PreviewHandler returns the handler of the resolv.conf preview endpoint, recovering from panics
+func (s *Server) PreviewHandler() http.Handler {
+	return s.recoverHandler(http.HandlerFunc(s.HandlePreview))
+}
+
+// HandlePreview answers with the resolv.conf kubelet generates for the pod in the request
+// body with the configuration in use, for clients presenting the preview bearer token
+func (s *Server) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.previewToken)) != 1 {
+		s.writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.writeErrorResponse(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	cfg := s.Config()
+	if cfg == nil {
+		s.writeErrorResponse(w, http.StatusServiceUnavailable, "Configuration not loaded")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestBytes))
+	if err != nil {
+		s.writeErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	objects, err := readManifests(bytes.NewReader(body))
+	if err != nil || len(objects) != 1 {
+		s.writeErrorResponse(w, http.StatusBadRequest, "Request body must be a single Pod or workload in YAML or JSON")
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	renderer := &manifestRenderer{server: s, namespace: namespace}
+	preview, err := previewResolvConf(r.Context(), renderer, objects[0], &resolvConf{searches: cfg.HostSearches})
+	if err != nil {
+		s.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Failed to preview: %v", err))
+		return
+	}
+	if preview.conf == nil {
+		s.writeErrorResponse(w, http.StatusUnprocessableEntity, fmt.Sprintf("Pod denied: %s", preview.rendered.message))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	writeResolvConfPreview(w, preview)
+}
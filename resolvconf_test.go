@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestKubeletResolvConf(t *testing.T) {
+	cfg := DefaultConfig()
+	host := &resolvConf{
+		nameservers: []string{"10.0.0.2"},
+		searches:    []string{"ec2.internal"},
+		options:     []string{"timeout:2"},
+	}
+	manySearches := make([]string, MaxSearchDomains)
+	for i := range manySearches {
+		manySearches[i] = fmt.Sprintf("d%d.example", i)
+	}
+
+	tests := []struct {
+		name           string
+		spec           podDNSSpec
+		want           string
+		wantViolations int
+	}{
+		{
+			name: "cluster first",
+			spec: podDNSSpec{},
+			want: "search shop.svc.cluster.local svc.cluster.local cluster.local ec2.internal\nnameserver 10.96.0.10\noptions ndots:5\n",
+		},
+		{
+			name: "cluster first with dnsConfig",
+			spec: podDNSSpec{
+				DNSPolicy: corev1.DNSClusterFirst,
+				DNSConfig: &corev1.PodDNSConfig{
+					Nameservers: []string{"169.254.20.10", "10.96.0.10"},
+					Searches:    []string{"corp.example", "cluster.local"},
+					Options:     []corev1.PodDNSConfigOption{{Name: "ndots", Value: ptr.To("2")}, {Name: "edns0"}},
+				},
+			},
+			want: "search shop.svc.cluster.local svc.cluster.local cluster.local ec2.internal corp.example\n" +
+				"nameserver 10.96.0.10\nnameserver 169.254.20.10\noptions ndots:2 edns0\n",
+		},
+		{
+			name: "host network falls back to the node",
+			spec: podDNSSpec{DNSPolicy: corev1.DNSClusterFirst, HostNetwork: true},
+			want: "search ec2.internal\nnameserver 10.0.0.2\noptions timeout:2\n",
+		},
+		{
+			name: "host network with cluster DNS",
+			spec: podDNSSpec{DNSPolicy: corev1.DNSClusterFirstWithHostNet, HostNetwork: true},
+			want: "search shop.svc.cluster.local svc.cluster.local cluster.local ec2.internal\nnameserver 10.96.0.10\noptions ndots:5\n",
+		},
+		{
+			name: "none",
+			spec: podDNSSpec{
+				DNSPolicy: corev1.DNSNone,
+				DNSConfig: &corev1.PodDNSConfig{Nameservers: []string{"169.254.20.10"}, Searches: []string{"shop.svc.cluster.local"}},
+			},
+			want: "search shop.svc.cluster.local\nnameserver 169.254.20.10\n",
+		},
+		{
+			name:           "none without nameservers",
+			spec:           podDNSSpec{DNSPolicy: corev1.DNSNone},
+			wantViolations: 1,
+		},
+		{
+			name: "beyond the kubelet limits",
+			spec: podDNSSpec{
+				DNSPolicy: corev1.DNSDefault,
+				DNSConfig: &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"}, Searches: manySearches},
+			},
+			want: "search ec2.internal " + strings.Join(manySearches[:MaxSearchDomains-1], " ") +
+				"\nnameserver 10.0.0.2\nnameserver 10.0.0.3\nnameserver 10.0.0.4\noptions timeout:2\n",
+			wantViolations: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, violations := kubeletResolvConf(cfg, &tt.spec, "shop", host)
+			if got := conf.String(); tt.want != "" && got != tt.want {
+				t.Errorf("resolv.conf =\n%s\nwant\n%s", got, tt.want)
+			}
+			if len(violations) != tt.wantViolations {
+				t.Errorf("violations = %q, want %d", violations, tt.wantViolations)
+			}
+		})
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	conf, err := parseResolvConf(strings.NewReader("# generated\nnameserver 10.0.0.2\ndomain old.example\nsearch a.example b.example. # comment\noptions ndots:2 rotate\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.String(), "search a.example b.example\nnameserver 10.0.0.2\noptions ndots:2 rotate\n"; got != want {
+		t.Errorf("resolv.conf =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestResolvConfCommand(t *testing.T) {
+	t.Setenv(EnvNodeLocalDNSAddress, "169.254.20.10")
+	pod := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n  namespace: shop\nspec:\n  containers:\n  - name: web\n    image: nginx\n"
+
+	var stdout, stderr bytes.Buffer
+	if code := runResolvConf(nil, strings.NewReader(pod), &stdout, &stderr); code != 0 {
+		t.Fatalf("exit status = %d: %s", code, stderr.String())
+	}
+	want := "# nodelocaldns: injected (Injected), profile default\n" +
+		"search shop.svc.cluster.local svc.cluster.local cluster.local\n" +
+		"nameserver 169.254.20.10\nnameserver 10.96.0.10\noptions ndots:3 attempts:2 timeout:1\n"
+	if stdout.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", stdout.String(), want)
+	}
+
+	denied := strings.Replace(pod, "  namespace: shop\n", "  annotations:\n    nodelocaldns.io/profile: missing\n", 1)
+	if code := runResolvConf(nil, strings.NewReader(denied), &stdout, &stderr); code != 2 {
+		t.Errorf("exit status = %d, want 2 for a denied pod", code)
+	}
+}
+
+func TestHandlePreview(t *testing.T) {
+	s := newTestServer()
+	s.previewToken = "secret"
+	pod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web"},"spec":{"containers":[{"name":"web","image":"nginx"}]}}`
+
+	// The preview is served on the operations listener, reachable without a client certificate
+	o := NewOpsServer(logr.Discard(), 0, NewReadiness())
+	o.Handle(PreviewPath, s.PreviewHandler())
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop(context.Background())
+	url := "http://" + o.listener.Addr().String() + PreviewPath + "?namespace=shop"
+
+	for token, wantStatus := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(pod))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != wantStatus {
+			t.Errorf("token %q: status = %d, want %d", token, resp.StatusCode, wantStatus)
+		}
+		if wantStatus == http.StatusOK && !strings.Contains(string(body), "search shop.svc.cluster.local svc.cluster.local cluster.local\nnameserver 169.254.20.10\n") {
+			t.Errorf("body = %s, want the resolv.conf of the pod", body)
+		}
+	}
+}
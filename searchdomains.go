@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Search path limits the API server validates pod DNS configurations against
+const (
+	MaxSearchDomains   = 32
+	MaxSearchListChars = 2048
+)
+
+// DefaultSearchTemplates are the search domains kubelet configures for ClusterFirst pods
+var DefaultSearchTemplates = []string{
+	"{{.Namespace}}.svc.{{.ClusterDomain}}",
+	"svc.{{.ClusterDomain}}",
+	"{{.ClusterDomain}}",
+}
+
+// withClusterDomains returns the search domain templates with those of the additional
+// cluster domains placed around them
+func withClusterDomains(cfg *Config, searches []string) []string {
+	if len(cfg.AdditionalClusterDomains) == 0 {
+		return searches
+	}
+
+	var before, after []string
+	for _, domain := range cfg.AdditionalClusterDomains {
+		// Domains are validated, so they cannot contain template actions
+		expansion := []string{
+			"{{.Namespace}}.svc." + domain.Domain,
+			"svc." + domain.Domain,
+		}
+		if domain.Position == ClusterDomainBefore {
+			before = append(before, expansion...)
+		} else {
+			after = append(after, expansion...)
+		}
+	}
+
+	templates := make([]string, 0, len(before)+len(searches)+len(after))
+	templates = append(templates, before...)
+	templates = append(templates, searches...)
+	return append(templates, after...)
+}
+
+// searchVars are the variables available to search domain templates
+type searchVars struct {
+	// Namespace is the namespace of the pod, taken from the request when the pod does not set it
+	Namespace string
+	// ClusterDomain is the k8s cluster domain
+	ClusterDomain string
+	// NamespaceLabels are the labels of the pod's namespace, empty if it is not known
+	NamespaceLabels map[string]string
+	// PodLabels are the labels of the pod
+	PodLabels map[string]string
+}
+
+// searchTemplates is a compiled list of search domain templates
+type searchTemplates struct {
+	templates []*template.Template
+	// labels is set when a template refers to labels, the rendered domains then vary per pod
+	labels bool
+}
+
+// compileSearchTemplates parses the search domain templates and checks they render
+func compileSearchTemplates(texts []string) (*searchTemplates, error) {
+	compiled := &searchTemplates{}
+	sample := &searchVars{Namespace: "default", ClusterDomain: "cluster.local"}
+	for _, text := range texts {
+		// Missing labels render empty, and the domain is then dropped
+		tmpl, err := template.New(text).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search domain template %q: %w", text, err)
+		}
+		// Fields that do not exist only fail on execution
+		if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+			return nil, fmt.Errorf("invalid search domain template %q: %w", text, err)
+		}
+		compiled.templates = append(compiled.templates, tmpl)
+		compiled.labels = compiled.labels || refersToLabels(tmpl)
+	}
+	return compiled, nil
+}
+
+// labelFields are the searchVars fields holding labels, which vary per pod
+var labelFields = []string{"NamespaceLabels", "PodLabels"}
+
+// refersToLabels reports whether the template, or one it defines, refers to a label field
+func refersToLabels(tmpl *template.Template) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeRefersToLabels(t.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeRefersToLabels walks a template parse tree for fields or variable fields naming a label field
+func nodeRefersToLabels(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		return slices.ContainsFunc(n.Nodes, nodeRefersToLabels)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		return slices.ContainsFunc(n.Cmds, func(cmd *parse.CommandNode) bool { return nodeRefersToLabels(cmd) })
+	case *parse.CommandNode:
+		return slices.ContainsFunc(n.Args, nodeRefersToLabels)
+	case *parse.ActionNode:
+		return nodeRefersToLabels(n.Pipe)
+	case *parse.TemplateNode:
+		return nodeRefersToLabels(n.Pipe)
+	case *parse.IfNode:
+		return nodeRefersToLabels(n.Pipe) || nodeRefersToLabels(n.List) || nodeRefersToLabels(n.ElseList)
+	case *parse.RangeNode:
+		return nodeRefersToLabels(n.Pipe) || nodeRefersToLabels(n.List) || nodeRefersToLabels(n.ElseList)
+	case *parse.WithNode:
+		return nodeRefersToLabels(n.Pipe) || nodeRefersToLabels(n.List) || nodeRefersToLabels(n.ElseList)
+	case *parse.FieldNode:
+		return namesLabelField(n.Ident)
+	case *parse.VariableNode:
+		// The first identifier is the variable itself, such as $
+		return namesLabelField(n.Ident[1:])
+	case *parse.ChainNode:
+		return nodeRefersToLabels(n.Node) || namesLabelField(n.Field)
+	}
+	return false
+}
+
+// namesLabelField reports whether a chain of field identifiers contains a label field
+func namesLabelField(idents []string) bool {
+	return slices.ContainsFunc(idents, func(ident string) bool { return slices.Contains(labelFields, ident) })
+}
+
+// render renders the search domains for a pod followed by the host search domains,
+// dropping empty, invalid or duplicate domains and those beyond the search path limits,
+// and returning a warning for each along with that about the limits, empty if none
+func (t *searchTemplates) render(vars *searchVars, hostSearches []string) ([]string, []string, string) {
+	searches := make([]string, 0, len(t.templates))
+	var warnings []string
+	for _, tmpl := range t.templates {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, vars); err != nil {
+			warnings = append(warnings, fmt.Sprintf("dropped search domain template %q: %v", tmpl.Name(), err))
+			continue
+		}
+		domain := strings.TrimSpace(b.String())
+		if domain == "" {
+			warnings = append(warnings, fmt.Sprintf("dropped search domain template %q: rendered empty", tmpl.Name()))
+			continue
+		}
+		if err := validateSearchDomain(domain); err != nil {
+			warnings = append(warnings, fmt.Sprintf("dropped search domain rendered from %q: %v", tmpl.Name(), err))
+			continue
+		}
+		searches = append(searches, domain)
+	}
+
+	// Like kubelet does for ClusterFirst, append the host search domains and omit duplicates
+	merged := make([]string, 0, len(searches)+len(hostSearches))
+	seen := make(map[string]bool, len(searches)+len(hostSearches))
+	for _, list := range [][]string{searches, hostSearches} {
+		for _, domain := range list {
+			if !seen[domain] {
+				seen[domain] = true
+				merged = append(merged, domain)
+			}
+		}
+	}
+	limited, truncated := limitSearches(merged)
+	if truncated != "" {
+		warnings = append(warnings, truncated)
+	}
+	return limited, warnings, truncated
+}
+
+// limitSearches drops the trailing search domains beyond the search path limits, returning
+// a warning if it dropped any
+func limitSearches(searches []string) ([]string, string) {
+	chars := -1
+	for i, domain := range searches {
+		// Domains are joined by a space in resolv.conf
+		chars += len(domain) + 1
+		if i >= MaxSearchDomains || chars > MaxSearchListChars {
+			return searches[:i], fmt.Sprintf("dropped search domains %q beyond the limit of %d domains or %d characters",
+				searches[i:], MaxSearchDomains, MaxSearchListChars)
+		}
+	}
+	return searches, ""
+}
+
+// readResolvConfSearches returns the search domains of a file in resolv.conf format
+func readResolvConfSearches(path string) ([]string, error) {
+	conf, err := readResolvConf(path)
+	if err != nil {
+		return nil, err
+	}
+	return conf.searches, nil
+}
+
+// validateSearchDomain validates a search domain the way the API server does
+func validateSearchDomain(domain string) error {
+	if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(domain, ".")); len(errs) > 0 {
+		return fmt.Errorf("invalid search domain %q: %s", domain, strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Validity of the generated certificates
+	CAValidity          = 5 * 365 * 24 * time.Hour
+	ServingCertValidity = 365 * 24 * time.Hour
+
+	// Remaining validity below which the generated certificates are rotated
+	CARotateBefore          = 180 * 24 * time.Hour
+	ServingCertRotateBefore = 30 * 24 * time.Hour
+
+	// CertCheckInterval is how often the leader checks the generated certificates
+	CertCheckInterval = 10 * time.Minute
+	// CertRetryInterval is how soon the leader retries after a failed check
+	CertRetryInterval = 10 * time.Second
+
+	// Keys of the self-signed certificate secret
+	SecretKeyCACert = "ca.crt"
+	SecretKeyCAKey  = "ca.key"
+)
+
+// CheckCertificateSecret is the readiness check name of the certificate secret cache
+const CheckCertificateSecret = "certificate-secret"
+
+// SelfSignedCertManager generates a CA and a serving certificate, stores them in a
+// Secret shared between replicas and keeps the caBundle of the webhook configuration
+// in sync. Only the elected leader runs it.
+type SelfSignedCertManager struct {
+	logger            logr.Logger
+	client            kubernetes.Interface
+	namespace         string
+	secretName        string
+	serviceName       string
+	clusterDomain     string
+	webhookConfigName string
+}
+
+// NewSelfSignedCertManager creates a new self-signed certificate manager
+func NewSelfSignedCertManager(logger logr.Logger, client kubernetes.Interface, namespace, secretName, serviceName, clusterDomain, webhookConfigName string) *SelfSignedCertManager {
+	return &SelfSignedCertManager{
+		logger:            logger,
+		client:            client,
+		namespace:         namespace,
+		secretName:        secretName,
+		serviceName:       serviceName,
+		clusterDomain:     clusterDomain,
+		webhookConfigName: webhookConfigName,
+	}
+}
+
+// Run reconciles the certificates periodically until the context is cancelled,
+// it must only run on the elected leader
+func (m *SelfSignedCertManager) Run(ctx context.Context) {
+	for {
+		interval := CertCheckInterval
+		if err := m.reconcile(ctx); err != nil {
+			recordError(ErrorClassCertificate)
+			m.logger.Error(err, "Failed to reconcile self-signed certificates")
+			interval = CertRetryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// reconcile ensures the secret holds a valid CA and serving certificate and that
+// the webhook configuration trusts the CA
+func (m *SelfSignedCertManager) reconcile(ctx context.Context) error {
+	secret, err := m.client.CoreV1().Secrets(m.namespace).Get(ctx, m.secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: m.namespace,
+				Name:      m.secretName,
+			},
+			Type: corev1.SecretTypeTLS,
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %w", m.namespace, m.secretName, err)
+	}
+
+	data, changed, err := m.rotate(secret.Data)
+	if err != nil {
+		return err
+	}
+
+	// Trust the new CA before serving certificates signed by it
+	if err := m.patchCABundle(ctx, data[SecretKeyCACert]); err != nil {
+		return err
+	}
+
+	if !changed {
+		return nil
+	}
+	secret.Data = data
+	if secret.ResourceVersion == "" {
+		_, err = m.client.CoreV1().Secrets(m.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		_, err = m.client.CoreV1().Secrets(m.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", m.namespace, m.secretName, err)
+	}
+	m.logger.Info("Stored self-signed certificates", "secret", m.namespace+"/"+m.secretName)
+	return nil
+}
+
+// rotate returns the secret data with the CA and serving certificate regenerated if
+// missing, invalid or close to expiry, and whether anything changed
+func (m *SelfSignedCertManager) rotate(data map[string][]byte) (map[string][]byte, bool, error) {
+	now := time.Now()
+	dnsNames := m.dnsNames()
+
+	caCert, caKey, previous, err := parseCA(data[SecretKeyCACert], data[SecretKeyCAKey])
+	caValid := err == nil && caCert.NotAfter.Sub(now) > CARotateBefore
+	if !caValid {
+		// Keep trusting the previous CA while serving certificates signed by it are still in use
+		previous = nil
+		if err == nil && now.Before(caCert.NotAfter) {
+			previous = caCert
+		}
+		caCert, caKey, err = generateCA(now)
+		if err != nil {
+			return nil, false, err
+		}
+		m.logger.Info("Generated self-signed CA", "notAfter", caCert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	serving, err := parseKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	servingValid := caValid && err == nil &&
+		serving.Leaf.NotAfter.Sub(now) > ServingCertRotateBefore &&
+		serving.Leaf.CheckSignatureFrom(caCert) == nil &&
+		coversDNSNames(serving.Leaf, dnsNames)
+	if caValid && servingValid {
+		return data, false, nil
+	}
+
+	certPEM, keyPEM, err := generateServingCert(now, caCert, caKey, dnsNames)
+	if err != nil {
+		return nil, false, err
+	}
+	m.logger.Info("Generated serving certificate", "dnsNames", dnsNames)
+
+	caKeyPEM, err := encodeECKey(caKey)
+	if err != nil {
+		return nil, false, err
+	}
+	bundle := encodeCert(caCert.Raw)
+	if previous != nil {
+		bundle = append(bundle, encodeCert(previous.Raw)...)
+	}
+	return map[string][]byte{
+		SecretKeyCACert:         bundle,
+		SecretKeyCAKey:          caKeyPEM,
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}, true, nil
+}
+
+// patchCABundle sets the caBundle of the webhooks served by this service
+func (m *SelfSignedCertManager) patchCABundle(ctx context.Context, caBundle []byte) error {
+	webhooks := m.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	config, err := webhooks.Get(ctx, m.webhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get mutating webhook configuration %s: %w", m.webhookConfigName, err)
+	}
+
+	changed := false
+	for i := range config.Webhooks {
+		clientConfig := &config.Webhooks[i].ClientConfig
+		if clientConfig.Service == nil ||
+			clientConfig.Service.Namespace != m.namespace ||
+			clientConfig.Service.Name != m.serviceName {
+			continue
+		}
+		if !bytes.Equal(clientConfig.CABundle, caBundle) {
+			clientConfig.CABundle = caBundle
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if _, err := webhooks.Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update caBundle of %s: %w", m.webhookConfigName, err)
+	}
+	m.logger.Info("Patched caBundle", "mutatingWebhookConfiguration", m.webhookConfigName)
+	return nil
+}
+
+// dnsNames returns the DNS names of the webhook service
+func (m *SelfSignedCertManager) dnsNames() []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", m.serviceName, m.namespace),
+		fmt.Sprintf("%s.%s.svc.%s", m.serviceName, m.namespace, m.clusterDomain),
+	}
+}
+
+// coversDNSNames reports whether the certificate is valid for every given name
+func coversDNSNames(cert *x509.Certificate, dnsNames []string) bool {
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCA parses the current CA, which comes first in the bundle, along with any previous CA
+func parseCA(bundlePEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, *x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := bundlePEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, nil, nil, errors.New("CA certificate not found")
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, nil, errors.New("CA private key not found")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse CA private key: %w", err)
+	}
+	if !key.PublicKey.Equal(certs[0].PublicKey) {
+		return nil, nil, nil, errors.New("CA private key does not match certificate")
+	}
+
+	var previous *x509.Certificate
+	if len(certs) > 1 && time.Now().Before(certs[1].NotAfter) {
+		previous = certs[1]
+	}
+	return certs[0], key, previous, nil
+}
+
+// generateCA creates a new self-signed CA
+func generateCA(now time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "nodelocaldns-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	return cert, key, nil
+}
+
+// generateServingCert creates a serving certificate for the given names signed by the CA
+func generateServingCert(now time.Time, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, dnsNames []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serving key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(ServingCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create serving certificate: %w", err)
+	}
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCert(der), keyPEM, nil
+}
+
+// randomSerial returns a random certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// encodeCert PEM encodes a DER certificate
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// encodeECKey PEM encodes an ECDSA private key
+func encodeECKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// SecretCertSource serves the key pair stored in a Secret, following its updates
+type SecretCertSource struct {
+	logger   logr.Logger
+	informer cache.SharedIndexInformer
+	factory  informers.SharedInformerFactory
+
+	// current is the key pair being served, with Leaf populated
+	current atomic.Pointer[tls.Certificate]
+}
+
+// NewSecretCertSource creates a certificate source backed by the named Secret and
+// registers a readiness check for its cache
+func NewSecretCertSource(logger logr.Logger, client kubernetes.Interface, namespace, name string, readiness *Readiness) *SecretCertSource {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	s := &SecretCertSource{
+		logger:   logger,
+		factory:  factory,
+		informer: factory.Core().V1().Secrets().Informer(),
+	}
+	s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.update,
+		UpdateFunc: func(_, obj interface{}) { s.update(obj) },
+	})
+	readiness.AddCacheSync(CheckCertificateSecret, s.informer.HasSynced)
+	return s
+}
+
+// Start watches the Secret until the context is cancelled
+func (s *SecretCertSource) Start(ctx context.Context) error {
+	s.factory.Start(ctx.Done())
+	return nil
+}
+
+// GetCertificate returns the current key pair, for use as tls.Config.GetCertificate
+func (s *SecretCertSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := s.current.Load()
+	if cert == nil {
+		return nil, errors.New("serving certificate not available yet")
+	}
+	return cert, nil
+}
+
+// Leaf returns the parsed leaf of the current certificate
+func (s *SecretCertSource) Leaf() *x509.Certificate {
+	if cert := s.current.Load(); cert != nil {
+		return cert.Leaf
+	}
+	return nil
+}
+
+// update loads the key pair from the Secret, keeping the current one on error
+func (s *SecretCertSource) update(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	cert, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		recordError(ErrorClassCertificate)
+		s.logger.Error(err, "Failed to load certificate from secret, keeping the current one",
+			"secret", secret.Namespace+"/"+secret.Name,
+		)
+		return
+	}
+	if swapCertificate(&s.current, cert) {
+		s.logger.Info("Loaded serving certificate from secret",
+			"secret", secret.Namespace+"/"+secret.Name,
+			"notAfter", cert.Leaf.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+}
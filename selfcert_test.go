@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestCertManager(client *fake.Clientset) *SelfSignedCertManager {
+	return NewSelfSignedCertManager(logr.Discard(), client, "kube-system", "nodelocaldns-webhook-self-signed",
+		"nodelocaldns-webhook", "cluster.local", "nodelocaldns-admission-controller")
+}
+
+// testCA returns a PEM encoded CA generated at the given time
+func testCA(t *testing.T, now time.Time) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	cert, key, err := generateCA(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, encodeCert(cert.Raw), keyPEM
+}
+
+func TestRotate(t *testing.T) {
+	m := newTestCertManager(nil)
+
+	data, changed, err := m.rotate(nil)
+	if err != nil || !changed {
+		t.Fatalf("rotate(nil) changed = %v, err = %v, want new certificates", changed, err)
+	}
+	caCert, _, previous, err := parseCA(data[SecretKeyCACert], data[SecretKeyCAKey])
+	if err != nil || previous != nil {
+		t.Fatalf("parseCA = %v, previous = %v, want a single CA", err, previous)
+	}
+	serving, err := parseKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serving.Leaf.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("serving certificate is not signed by the CA: %v", err)
+	}
+	if !coversDNSNames(serving.Leaf, m.dnsNames()) {
+		t.Errorf("serving certificate names = %v, want %v", serving.Leaf.DNSNames, m.dnsNames())
+	}
+
+	// Valid certificates are kept
+	if kept, changed, err := m.rotate(data); err != nil || changed || !bytes.Equal(kept[corev1.TLSCertKey], data[corev1.TLSCertKey]) {
+		t.Errorf("rotate changed = %v, err = %v, want the certificates kept", changed, err)
+	}
+
+	// A serving certificate for other names is reissued by the same CA
+	renamed := newTestCertManager(nil)
+	renamed.serviceName = "other"
+	reissued, changed, err := renamed.rotate(data)
+	if err != nil || !changed {
+		t.Fatalf("rotate changed = %v, err = %v, want a new serving certificate", changed, err)
+	}
+	if !bytes.Equal(reissued[SecretKeyCACert], data[SecretKeyCACert]) {
+		t.Error("CA was regenerated, want it kept")
+	}
+
+	// A CA close to expiry is replaced, and trusted alongside the new one until it expires
+	expiring, expiringPEM, expiringKeyPEM := testCA(t, time.Now().Add(CARotateBefore/2-CAValidity))
+	rotated, changed, err := m.rotate(map[string][]byte{
+		SecretKeyCACert:         expiringPEM,
+		SecretKeyCAKey:          expiringKeyPEM,
+		corev1.TLSCertKey:       data[corev1.TLSCertKey],
+		corev1.TLSPrivateKeyKey: data[corev1.TLSPrivateKeyKey],
+	})
+	if err != nil || !changed {
+		t.Fatalf("rotate changed = %v, err = %v, want a new CA", changed, err)
+	}
+	newCA, _, previous, err := parseCA(rotated[SecretKeyCACert], rotated[SecretKeyCAKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCA.Equal(expiring) || previous == nil || !previous.Equal(expiring) {
+		t.Errorf("CA = %s, previous = %v, want a new CA followed by the expiring one", newCA.NotAfter, previous)
+	}
+	serving, err = parseKeyPair(rotated[corev1.TLSCertKey], rotated[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serving.Leaf.CheckSignatureFrom(newCA); err != nil {
+		t.Errorf("serving certificate is not signed by the new CA: %v", err)
+	}
+}
+
+func TestParseCA(t *testing.T) {
+	now := time.Now()
+	current, currentPEM, currentKeyPEM := testCA(t, now)
+	previous, previousPEM, _ := testCA(t, now.Add(-CAValidity/2))
+	_, expiredPEM, _ := testCA(t, now.Add(-2*CAValidity))
+	_, _, otherKeyPEM := testCA(t, now)
+
+	tests := []struct {
+		name         string
+		bundle       []byte
+		key          []byte
+		wantErr      bool
+		wantPrevious *x509.Certificate
+	}{
+		{name: "current CA", bundle: currentPEM, key: currentKeyPEM},
+		{name: "with previous CA", bundle: append(append([]byte(nil), currentPEM...), previousPEM...), key: currentKeyPEM, wantPrevious: previous},
+		{name: "expired previous CA", bundle: append(append([]byte(nil), currentPEM...), expiredPEM...), key: currentKeyPEM},
+		{name: "missing certificate", key: currentKeyPEM, wantErr: true},
+		{name: "missing key", bundle: currentPEM, wantErr: true},
+		{name: "key of another CA", bundle: currentPEM, key: otherKeyPEM, wantErr: true},
+		{name: "invalid certificate", bundle: []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), key: currentKeyPEM, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key, prev, err := parseCA(tt.bundle, tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseCA succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert.Equal(current) || !key.PublicKey.Equal(current.PublicKey) {
+				t.Error("parseCA did not return the current CA")
+			}
+			if (prev == nil) != (tt.wantPrevious == nil) || (prev != nil && !prev.Equal(tt.wantPrevious)) {
+				t.Errorf("previous = %v, want %v", prev, tt.wantPrevious)
+			}
+		})
+	}
+}
+
+func TestPatchCABundle(t *testing.T) {
+	service := func(name string) admissionregistrationv1.WebhookClientConfig {
+		return admissionregistrationv1.WebhookClientConfig{
+			Service:  &admissionregistrationv1.ServiceReference{Namespace: "kube-system", Name: name},
+			CABundle: []byte("old"),
+		}
+	}
+	client := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodelocaldns-admission-controller"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: WebhookName, ClientConfig: service("nodelocaldns-webhook")},
+			{Name: "other.k8s.io", ClientConfig: service("other")},
+		},
+	})
+	m := newTestCertManager(client)
+	ctx := context.Background()
+
+	if err := m.patchCABundle(ctx, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "nodelocaldns-admission-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(config.Webhooks[0].ClientConfig.CABundle); got != "new" {
+		t.Errorf("caBundle = %q, want it patched", got)
+	}
+	if got := string(config.Webhooks[1].ClientConfig.CABundle); got != "old" {
+		t.Errorf("caBundle of another service = %q, want it kept", got)
+	}
+
+	// An up to date caBundle needs no update
+	client.ClearActions()
+	if err := m.patchCABundle(ctx, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("got %s %s, want no update", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	// The configuration must exist
+	m.webhookConfigName = "missing"
+	if err := m.patchCABundle(ctx, []byte("new")); err == nil {
+		t.Error("patchCABundle succeeded without a webhook configuration")
+	}
+}
@@ -3,16 +3,29 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"runtime/debug"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -28,90 +41,245 @@ const (
 	InjectPath = "/inject"
 	HealthPath = "/health"
 	ReadyPath  = "/ready"
+
+	// DefaultMaxRequestBytes is the default limit of an admission request body
+	DefaultMaxRequestBytes = 7 * 1024 * 1024
+
+	// Default shutdown timings, the drain period lets the API server observe the
+	// replica is not ready before the listener closes
+	DefaultShutdownDrainPeriod = 10 * time.Second
+	DefaultShutdownGracePeriod = 15 * time.Second
+
+	// CertificateExpiryThreshold is the remaining validity below which the serving certificate is not ready
+	CertificateExpiryThreshold = 24 * time.Hour
+
+	// HostResolvConfReloadInterval is how often the host resolv.conf is re-read in case a file event was missed
+	HostResolvConfReloadInterval = time.Minute
+
+	// Readiness check names
+	CheckServing     = "serving"
+	CheckConfig      = "config"
+	CheckCertificate = "certificate"
+	CheckClusterDNS  = "cluster-dns"
+	CheckNamespaces  = "namespaces"
 )
 
+// CertificateSource provides the serving certificate and keeps it up to date
+type CertificateSource interface {
+	// Start keeps the certificate up to date until the context is cancelled
+	Start(ctx context.Context) error
+	// GetCertificate returns the current key pair, for use as tls.Config.GetCertificate
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// Leaf returns the parsed leaf of the current certificate, nil if none is loaded
+	Leaf() *x509.Certificate
+}
+
+// ServerOptions represents the listener settings of the webhook server
+type ServerOptions struct {
+	// Port is the port to listen on
+	Port int
+	// Certs provides the serving certificate
+	Certs CertificateSource
+	// TLSPolicy restricts the TLS settings, Go defaults if nil
+	TLSPolicy *TLSPolicy
+	// ClientAuth verifies client certificates, none are required if nil
+	ClientAuth *ClientAuth
+	// MaxRequestBytes is the limit of an admission request body, DefaultMaxRequestBytes if 0
+	MaxRequestBytes int64
+	// ShutdownGracePeriod is how long in-flight requests may take to finish on shutdown
+	ShutdownGracePeriod time.Duration
+	// MaxInFlight is the number of admission requests handled concurrently, unlimited if 0
+	MaxInFlight int
+	// MaxQueued is the number of admission requests waiting for a slot before shedding
+	MaxQueued int
+	// QueueTimeout is how long a queued admission request waits before being shed
+	QueueTimeout time.Duration
+	// OverloadDecision is the answer to shed requests, OverloadAllow or OverloadDeny
+	OverloadDecision string
+	// Namespaces looks up the namespaces selecting DNS profiles, only pods select them if nil
+	Namespaces corelisters.NamespaceLister
+	// DecisionLog records every admission decision, none are recorded if nil
+	DecisionLog *DecisionLog
+	// TracerProvider traces admission requests, none are traced if nil
+	TracerProvider trace.TracerProvider
+	// Events records events about denied, failed and noteworthy skipped admissions, none are recorded if nil
+	Events record.EventRecorder
+	// PreviewToken is the bearer token of the resolv.conf preview endpoint, which is not served if empty
+	PreviewToken string
+}
+
 // Server implements the WebhookServer interface
 type Server struct {
-	logger   logr.Logger
-	server   *http.Server
-	config   *Config
-	port     int
-	certFile string
-	keyFile  string
+	logger              logr.Logger
+	server              *http.Server
+	port                int
+	certs               CertificateSource
+	maxRequestBytes     int64
+	shutdownGracePeriod time.Duration
+	namespaces          corelisters.NamespaceLister
+	decisions           *DecisionLog
+	tracer              trace.Tracer
+	events              record.EventRecorder
+	previewToken        string
+
+	// config is the configuration in use, nil until loaded
+	config atomic.Pointer[Config]
+	// patches caches the JSON patch per namespace and profile for the configuration in use
+	patches patchCache
+	// serving is set once the listener is bound, draining once shutdown has begun
+	serving  atomic.Bool
+	draining atomic.Bool
 }
 
-// NewServer creates a new webhook server
-func NewServer(logger logr.Logger, port int, certFile, keyFile string, cfg *Config) (*Server, error) {
+// NewServer creates a new webhook server, the configuration is set with SetConfig
+func NewServer(logger logr.Logger, opts ServerOptions, readiness *Readiness) (*Server, error) {
 	server := &Server{
-		logger:   logger,
-		config:   cfg,
-		port:     port,
-		certFile: certFile,
-		keyFile:  keyFile,
+		logger:              logger,
+		port:                opts.Port,
+		certs:               opts.Certs,
+		maxRequestBytes:     opts.MaxRequestBytes,
+		shutdownGracePeriod: opts.ShutdownGracePeriod,
+		namespaces:          opts.Namespaces,
+		decisions:           opts.DecisionLog,
+		events:              opts.Events,
+		previewToken:        opts.PreviewToken,
+	}
+	if server.maxRequestBytes <= 0 {
+		server.maxRequestBytes = DefaultMaxRequestBytes
+	}
+	if server.shutdownGracePeriod <= 0 {
+		server.shutdownGracePeriod = DefaultShutdownGracePeriod
+	}
+	tracerProvider := opts.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
 	}
+	server.tracer = tracerProvider.Tracer(TracerName)
+
+	readiness.Add(CheckServing, server.checkServing)
+	readiness.Add(CheckConfig, server.checkConfig)
+	readiness.Add(CheckCertificate, server.checkCertificate)
+	readiness.Add(CheckClusterDNS, server.checkClusterDNS)
 
 	// Create HTTP server with TLS configuration
 	mux := http.NewServeMux()
-	mux.HandleFunc(InjectPath, server.HandleInject)
-	mux.HandleFunc(HealthPath, server.handleHealth)
-	mux.HandleFunc(ReadyPath, server.handleReady)
-
+	var inject http.Handler = http.HandlerFunc(server.HandleInject)
+	if opts.MaxInFlight > 0 {
+		inject = server.limitAdmission(newAdmissionLimiter(opts.MaxInFlight, opts.MaxQueued, opts.QueueTimeout), opts.OverloadDecision, inject)
+	}
+	// Recover from panics in the shed path too
+	mux.Handle(InjectPath, server.recoverAdmission(inject))
+	mux.HandleFunc(HealthPath, handleHealth)
+	mux.Handle(ReadyPath, readiness)
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: opts.Certs.GetCertificate,
+	}
 	server.server = &http.Server{
-		Addr:         ":" + strconv.Itoa(port),
+		Addr:         ":" + strconv.Itoa(opts.Port),
 		Handler:      mux,
 		ReadTimeout:  ReadTimeout,
 		WriteTimeout: WriteTimeout,
 		IdleTimeout:  IdleTimeout,
-		TLSConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
+		TLSConfig:    tlsConfig,
+	}
+
+	// Restrict TLS versions, cipher suites, curves and HTTP/2, when configured
+	if opts.TLSPolicy != nil {
+		opts.TLSPolicy.Apply(tlsConfig, server.server)
+	}
+	// Only accept clients presenting an allowed certificate, when configured
+	if opts.ClientAuth != nil {
+		opts.ClientAuth.Apply(tlsConfig)
 	}
 
 	return server, nil
 }
 
-// Start begins serving the webhook server
+// Config returns the configuration in use, nil until loaded
+func (s *Server) Config() *Config {
+	return s.config.Load()
+}
+
+// SetConfig replaces the configuration in use
+func (s *Server) SetConfig(cfg *Config) {
+	s.config.Store(cfg)
+	configGeneration.Inc()
+}
+
+// WatchHostResolvConf reloads the host search domains whenever the host resolv.conf of the
+// configuration in use changes, until the context is cancelled
+func (s *Server) WatchHostResolvConf(ctx context.Context) error {
+	cfg := s.Config()
+	if cfg == nil || cfg.HostResolvConf == "" {
+		return nil
+	}
+	return watchFiles(ctx, s.logger, []string{cfg.HostResolvConf}, HostResolvConfReloadInterval, s.reloadHostResolvConf)
+}
+
+// reloadHostResolvConf swaps in the configuration with the current host search domains,
+// keeping the configuration in use on error
+func (s *Server) reloadHostResolvConf() {
+	cfg := s.Config()
+	reloaded, err := cfg.ReloadHostResolvConf()
+	if err != nil {
+		recordError(ErrorClassConfig)
+		s.logger.Error(err, "Failed to reload host search domains, keeping the current ones")
+		return
+	}
+	if reloaded != cfg {
+		s.SetConfig(reloaded)
+		s.logger.Info("Reloaded host search domains", "hostSearches", reloaded.HostSearches)
+	}
+}
+
+// Start binds the listener and begins serving in the background, returning once
+// the listener is bound or failed to bind
 func (s *Server) Start(ctx context.Context) error {
-	s.logger.Info("Starting webhook server",
-		"port", s.port,
-		"certFile", s.certFile,
-		"keyFile", s.keyFile,
-	)
+	s.logger.Info("Starting webhook server", "port", s.port)
 
-	// Validate TLS certificate files
-	if err := s.validateCertificates(); err != nil {
-		s.logger.Error(err, "Certificate validation failed")
-		return fmt.Errorf("certificate validation failed: %w", err)
+	// Keep the serving certificate up to date
+	if err := s.certs.Start(ctx); err != nil {
+		s.logger.Error(err, "Failed to start certificate source")
+		return fmt.Errorf("failed to start certificate source: %w", err)
 	}
 
-	// Start server in a goroutine
-	errChan := make(chan error, 1)
+	// Bind synchronously so errors such as the port being in use surface at once
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		s.logger.Error(err, "Failed to bind webhook listener")
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+	s.serving.Store(true)
+
+	// Serve in a goroutine, the certificate is served through GetCertificate
 	go func() {
-		if err := s.server.ListenAndServeTLS(s.certFile, s.keyFile); err != nil && err != http.ErrServerClosed {
-			errChan <- fmt.Errorf("failed to start HTTPS server: %w", err)
+		if err := s.server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.serving.Store(false)
+			recordError(ErrorClassServe)
+			s.logger.Error(err, "Webhook server failed")
 		}
 	}()
 
-	// Wait for either context cancellation or server error
-	select {
-	case <-ctx.Done():
-		s.logger.Info("Context cancelled, shutting down server")
-		return s.Stop(context.Background())
-	case err := <-errChan:
-		s.logger.Error(err, "Server failed to start")
-		return err
-	case <-time.After(2 * time.Second):
-		// Server started successfully
-		s.logger.Info("Webhook server started successfully", "port", s.port)
-		return nil
-	}
+	s.logger.Info("Webhook server started successfully", "port", s.port)
+	return nil
+}
+
+// Drain fails readiness so that the API server stops routing requests to this
+// replica, while requests keep being served until Stop
+func (s *Server) Drain() {
+	s.logger.Info("Draining webhook server")
+	s.draining.Store(true)
 }
 
-// Stop gracefully shuts down the webhook server
+// Stop gracefully shuts down the webhook server, waiting for in-flight requests
+// up to the shutdown grace period
 func (s *Server) Stop(ctx context.Context) error {
-	s.logger.Info("Stopping webhook server")
+	s.logger.Info("Stopping webhook server", "gracePeriod", s.shutdownGracePeriod)
+	s.draining.Store(true)
 
-	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(ctx, s.shutdownGracePeriod)
 	defer cancel()
 
 	if err := s.server.Shutdown(shutdownCtx); err != nil {
@@ -126,45 +294,144 @@ func (s *Server) Stop(ctx context.Context) error {
 // HandleInject processes admission requests for DNS configuration injection
 func (s *Server) HandleInject(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	defer func() {
+		admissionDuration.Observe(time.Since(startTime).Seconds())
+	}()
 
 	s.logger.V(3).Info("Processing admission request", "method", r.Method, "url", r.URL.Path)
 
+	// Continue the trace of the caller, such as the API server, when it sent one
+	ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, SpanAdmission, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	// Validate request method
 	if r.Method != http.MethodPost {
 		s.logger.Error(fmt.Errorf("method not allowed"), "Invalid request method", "method", r.Method)
+		recordError(ErrorClassMethod)
+		span.SetStatus(codes.Error, "method not allowed")
 		s.writeErrorResponse(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
 		return
 	}
 
-	// Validate content type
+	// Validate content type, allowing parameters such as charset
 	contentType := r.Header.Get("Content-Type")
-	if contentType != ContentTypeJSON {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != ContentTypeJSON {
 		s.logger.Error(fmt.Errorf("content type mismatch"), "Invalid content type", "contentType", contentType)
-		s.writeErrorResponse(w, http.StatusBadRequest, "Content-Type must be application/json")
+		recordError(ErrorClassContentType)
+		span.SetStatus(codes.Error, "content type mismatch")
+		s.writeErrorResponse(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
 		return
 	}
 
-	// Read request body
-	body, err := io.ReadAll(r.Body)
+	// Read request body, up to the size limit
+	_, decodeSpan := s.tracer.Start(ctx, SpanDecode)
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestBytes))
 	if err != nil {
 		s.logger.Error(err, "Failed to read request body")
+		recordError(ErrorClassReadBody)
+		endSpan(decodeSpan, err)
+		span.SetStatus(codes.Error, "failed to read request body")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.writeErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
 		s.writeErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
-	defer r.Body.Close()
 
 	// Parse admission review request
 	var admissionReview admissionv1.AdmissionReview
 	if err := json.Unmarshal(body, &admissionReview); err != nil {
 		s.logger.Error(err, "Failed to unmarshal admission review")
+		recordError(ErrorClassDecode)
+		endSpan(decodeSpan, err)
+		span.SetStatus(codes.Error, "failed to parse admission review")
 		s.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse admission review: %v", err))
 		return
 	}
 
+	// Validate the admission request
+	if err := validateAdmissionRequest(admissionReview.Request); err != nil {
+		s.logger.Error(err, "Invalid admission review")
+		recordError(ErrorClassInvalidRequest)
+		endSpan(decodeSpan, err)
+		span.SetStatus(codes.Error, "invalid admission review")
+		s.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid admission review: %v", err))
+		return
+	}
+
+	decodeSpan.End()
+	setAdmissionUID(r.Context(), admissionReview.Request.UID)
+
 	// Process the admission request
-	response := s.processAdmissionRequest(admissionReview.Request)
+	response := s.processAdmissionRequest(ctx, admissionReview.Request)
+
+	// Write admission review response
+	_, encodeSpan := s.tracer.Start(ctx, SpanEncode)
+	s.writeAdmissionReview(w, response)
+	encodeSpan.End()
+
+	// Log response
+	result := "success"
+	if !response.Allowed {
+		result = "failure"
+	}
+	s.logger.V(3).Info("Admission request processed",
+		"Duration", time.Since(startTime),
+		"result", result,
+		"allowed", response.Allowed,
+	)
+}
+
+// checkServing reports whether the listener is serving and not draining
+func (s *Server) checkServing() error {
+	if s.draining.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	if !s.serving.Load() {
+		return fmt.Errorf("listener not serving")
+	}
+	return nil
+}
+
+// checkConfig reports whether the configuration is loaded and valid
+func (s *Server) checkConfig() error {
+	cfg := s.Config()
+	if cfg == nil {
+		return fmt.Errorf("configuration not loaded")
+	}
+	return validateConfig(cfg)
+}
 
-	// Create admission review response
+// checkCertificate reports whether a valid serving certificate is loaded and not about to expire
+func (s *Server) checkCertificate() error {
+	cert := s.certs.Leaf()
+	if cert == nil {
+		return fmt.Errorf("certificate not loaded")
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if remaining := cert.NotAfter.Sub(now); remaining < CertificateExpiryThreshold {
+		return fmt.Errorf("certificate expires at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkClusterDNS reports whether the cluster DNS address is known
+func (s *Server) checkClusterDNS() error {
+	if cfg := s.Config(); cfg == nil || cfg.ClusterDNSAddress == "" {
+		return fmt.Errorf("cluster DNS address unknown")
+	}
+	return nil
+}
+
+// writeAdmissionReview writes an admission review holding the response to the client
+func (s *Server) writeAdmissionReview(w http.ResponseWriter, response *admissionv1.AdmissionResponse) {
 	admissionResponse := &admissionv1.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "admission.k8s.io/v1",
@@ -177,6 +444,7 @@ func (s *Server) HandleInject(w http.ResponseWriter, r *http.Request) {
 	responseBytes, err := json.Marshal(admissionResponse)
 	if err != nil {
 		s.logger.Error(err, "Failed to marshal response")
+		recordError(ErrorClassEncode)
 		s.writeErrorResponse(w, http.StatusInternalServerError, "Failed to marshal response")
 		return
 	}
@@ -186,33 +454,119 @@ func (s *Server) HandleInject(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(responseBytes); err != nil {
 		s.logger.Error(err, "Failed to write response")
+		recordError(ErrorClassWrite)
 	}
+}
 
-	// Log response
-	result := "success"
-	if !response.Allowed {
-		result = "failure"
+// validateAdmissionRequest validates that an admission review carries a usable request
+func validateAdmissionRequest(req *admissionv1.AdmissionRequest) error {
+	if req == nil {
+		return fmt.Errorf("request is missing")
 	}
-	s.logger.V(3).Info("Admission request processed",
-		"Duration", time.Since(startTime),
-		"result", result,
-		"allowed", response.Allowed,
-	)
+	if req.UID == "" {
+		return fmt.Errorf("request UID is missing")
+	}
+	return nil
 }
 
-// validateCertificates validates that the TLS certificate files exist and are valid
-func (s *Server) validateCertificates() error {
-	// Load certificate to validate it
-	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
-	if err != nil {
-		return fmt.Errorf("failed to load certificate pair: %w", err)
+// recoverAdmission recovers from panics while handling an admission request, answering
+// with an allowed or denied response according to the webhook failure policy
+func (s *Server) recoverAdmission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler records the request UID here once decoded, to answer with it
+		uid := new(types.UID)
+		r = r.WithContext(context.WithValue(r.Context(), admissionUIDKey{}, uid))
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			recordError(ErrorClassPanic)
+			s.logger.Error(fmt.Errorf("%v", recovered), "Panic while handling admission request",
+				"stack", string(debug.Stack()),
+			)
+
+			if *uid == "" {
+				s.writeErrorResponse(w, http.StatusInternalServerError, "Internal error handling admission request")
+				return
+			}
+
+			response := &admissionv1.AdmissionResponse{
+				UID:     *uid,
+				Allowed: true,
+			}
+			if cfg := s.Config(); cfg != nil && cfg.Webhook.FailurePolicy == FailurePolicyFail {
+				response = s.createErrorResponse(string(*uid), "Internal error handling admission request")
+				response.Result.Code = http.StatusInternalServerError
+			}
+			s.writeAdmissionReview(w, response)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverHandler recovers from panics while handling a request other than an admission
+// request, answering with an internal error
+func (s *Server) recoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			recordError(ErrorClassPanic)
+			s.logger.Error(fmt.Errorf("%v", recovered), "Panic while handling request",
+				"path", r.URL.Path,
+				"stack", string(debug.Stack()),
+			)
+			s.writeErrorResponse(w, http.StatusInternalServerError, "Internal error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// admissionRequestHeader holds the fields of an admission request needed to answer it
+// without decoding the objects
+type admissionRequestHeader struct {
+	UID       types.UID             `json:"uid"`
+	Namespace string                `json:"namespace"`
+	Operation admissionv1.Operation `json:"operation"`
+	DryRun    *bool                 `json:"dryRun,omitempty"`
+}
+
+// peekAdmissionRequest decodes the header of the request of an admission review
+func peekAdmissionRequest(body []byte) (*admissionRequestHeader, error) {
+	var review struct {
+		Request *admissionRequestHeader `json:"request"`
+	}
+	if err := json.Unmarshal(body, &review); err != nil {
+		return nil, err
+	}
+	if review.Request == nil || review.Request.UID == "" {
+		return nil, fmt.Errorf("request UID is missing")
 	}
+	return review.Request, nil
+}
+
+// admissionUIDKey is the context key of the UID of the admission request being handled
+type admissionUIDKey struct{}
 
-	// Basic validation - ensure certificate is not nil
-	if len(cert.Certificate) == 0 {
-		return fmt.Errorf("certificate is empty")
+// setAdmissionUID records the UID of the decoded admission request for recoverAdmission
+func setAdmissionUID(ctx context.Context, uid types.UID) {
+	if slot, ok := ctx.Value(admissionUIDKey{}).(*types.UID); ok {
+		*slot = uid
 	}
-	return nil
 }
 
 // writeErrorResponse writes an error response to the client
@@ -236,31 +590,3 @@ func (s *Server) writeErrorResponse(w http.ResponseWriter, statusCode int, messa
 		s.logger.Error(err, "Failed to write error response")
 	}
 }
-
-// handleHealth handles health check requests
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", ContentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-
-	response := map[string]string{
-		"status": "healthy",
-		"time":   time.Now().UTC().Format(time.RFC3339),
-	}
-
-	json.NewEncoder(w).Encode(response)
-}
-
-// handleReady handles readiness check requests
-func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
-	// Configuration is loaded at startup, so we're always ready if the server is running
-	w.Header().Set("Content-Type", ContentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-
-	response := map[string]string{
-		"status": "ready",
-		"time":   time.Now().UTC().Format(time.RFC3339),
-	}
-
-	json.NewEncoder(w).Encode(response)
-}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace/noop"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const testPodReview = `{
+	"apiVersion": "admission.k8s.io/v1",
+	"kind": "AdmissionReview",
+	"request": {
+		"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+		"kind": {"group": "", "version": "v1", "kind": "Pod"},
+		"resource": {"group": "", "version": "v1", "resource": "pods"},
+		"namespace": "default",
+		"operation": "CREATE",
+		"object": {
+			"apiVersion": "v1",
+			"kind": "Pod",
+			"metadata": {"name": "test", "namespace": "default"},
+			"spec": {"dnsPolicy": "ClusterFirst", "containers": [{"name": "app", "image": "nginx"}]}
+		}
+	}
+}`
+
+// newTestServer creates a server with the default configuration and no listener
+func newTestServer() *Server {
+	s := &Server{
+		logger:          logr.Discard(),
+		maxRequestBytes: DefaultMaxRequestBytes,
+		tracer:          noop.NewTracerProvider().Tracer(TracerName),
+	}
+	s.SetConfig(DefaultConfig())
+	return s
+}
+
+// postReview posts a body to the handler and returns the recorded response
+func postReview(handler http.Handler, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, InjectPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleInject(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int64
+		wantStatus  int
+		wantPatch   bool
+	}{
+		{name: "pod", contentType: "application/json", body: testPodReview, wantStatus: http.StatusOK, wantPatch: true},
+		{name: "charset", contentType: "application/json; charset=utf-8", body: testPodReview, wantStatus: http.StatusOK, wantPatch: true},
+		{name: "wrong content type", contentType: "text/plain", body: testPodReview, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "empty review", contentType: "application/json", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "missing uid", contentType: "application/json", body: `{"request":{}}`, wantStatus: http.StatusBadRequest},
+		{name: "too large", contentType: "application/json", body: testPodReview, maxBytes: 16, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			if tt.maxBytes != 0 {
+				s.maxRequestBytes = tt.maxBytes
+			}
+
+			rec := postReview(http.HandlerFunc(s.HandleInject), tt.contentType, []byte(tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got := review.Response.Patch != nil; got != tt.wantPatch {
+				t.Errorf("patch present = %v, want %v", got, tt.wantPatch)
+			}
+		})
+	}
+}
+
+func TestRecoverAdmission(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review admissionv1.AdmissionReview
+		json.NewDecoder(r.Body).Decode(&review)
+		setAdmissionUID(r.Context(), review.Request.UID)
+		panic("boom")
+	})
+
+	for _, policy := range []string{FailurePolicyIgnore, FailurePolicyFail} {
+		t.Run(policy, func(t *testing.T) {
+			s := newTestServer()
+			s.Config().Webhook.FailurePolicy = policy
+
+			rec := postReview(s.recoverAdmission(panicking), ContentTypeJSON, []byte(testPodReview))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if review.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+				t.Errorf("UID = %q, want the request UID", review.Response.UID)
+			}
+			if want := policy == FailurePolicyIgnore; review.Response.Allowed != want {
+				t.Errorf("allowed = %v, want %v", review.Response.Allowed, want)
+			}
+		})
+	}
+
+	// Without a decoded request there is no UID to answer with
+	early := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+	if rec := postReview(newTestServer().recoverAdmission(early), ContentTypeJSON, []byte(testPodReview)); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRecoverHandler(t *testing.T) {
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") })
+	before := testutil.ToFloat64(errorsTotal.WithLabelValues(ErrorClassPanic))
+	rec := httptest.NewRecorder()
+	newTestServer().recoverHandler(panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, PreviewPath, nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := testutil.ToFloat64(errorsTotal.WithLabelValues(ErrorClassPanic)) - before; got != 1 {
+		t.Errorf("panics recorded = %v, want 1", got)
+	}
+}
+
+func TestWatchHostResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("search corp.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.HostSearches = []string{"static.example.com"}
+	cfg.HostResolvConf = path
+	if err := completeConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer()
+	s.SetConfig(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.WatchHostResolvConf(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The search domains of the rewritten file replace the previous ones once it changes
+	if err := os.WriteFile(path, []byte("search eng.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"static.example.com", "eng.example.com"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(s.Config().HostSearches, want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("host searches = %q, want %q", s.Config().HostSearches, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Invalid search domains keep the configuration in use
+	reloaded := s.Config()
+	if err := os.WriteFile(path, []byte("search -invalid-\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadHostResolvConf()
+	if s.Config() != reloaded {
+		t.Errorf("host searches = %q, want the invalid ones ignored", s.Config().HostSearches)
+	}
+}
+
+func FuzzHandleInject(f *testing.F) {
+	f.Add([]byte(testPodReview))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"request":null}`))
+	f.Add([]byte(`{"request":{"uid":"1","kind":{"kind":"Pod"},"resource":{"resource":"pods"},"operation":"CREATE"}}`))
+	f.Add([]byte(`{"request":{"uid":"1","kind":{"kind":"Pod"},"resource":{"resource":"pods"},"operation":"CREATE","object":{"spec":{"dnsConfig":{}}}}}`))
+	f.Add([]byte(`{"request":{"uid":"1","kind":{"kind":"Pod"},"resource":{"resource":"pods"},"operation":"UPDATE"}}`))
+	f.Add([]byte(`not json`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		s := newTestServer()
+
+		// Call the handler without the recovery middleware so that panics fail the test
+		rec := postReview(http.HandlerFunc(s.HandleInject), ContentTypeJSON, body)
+
+		switch rec.Code {
+		case http.StatusOK:
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+			return
+		default:
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var request admissionv1.AdmissionReview
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Fatalf("handler accepted a body that does not decode: %v", err)
+		}
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if review.Response == nil {
+			t.Fatal("response is missing")
+		}
+		if review.Response.UID != request.Request.UID {
+			t.Errorf("UID = %q, want %q", review.Response.UID, request.Request.UID)
+		}
+		if review.Response.Patch != nil {
+			var patch []map[string]interface{}
+			if err := json.Unmarshal(review.Response.Patch, &patch); err != nil {
+				t.Errorf("patch is not a JSON patch: %v", err)
+			}
+		}
+	})
+}
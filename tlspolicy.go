@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// tlsVersions are the accepted TLS versions, older versions are insecure
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the accepted key exchange curves
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+// http2RequiredCiphers are the TLS 1.2 cipher suites of which HTTP/2 requires at least one
+var http2RequiredCiphers = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// TLSPolicy represents the TLS settings of the webhook listener
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version accepted
+	MinVersion uint16
+	// MaxVersion is the maximum TLS version accepted, 0 for the latest supported
+	MaxVersion uint16
+	// CipherSuites are the TLS 1.2 cipher suites accepted, Go defaults if empty
+	CipherSuites []uint16
+	// CurvePreferences are the key exchange curves in preference order, Go defaults if empty
+	CurvePreferences []tls.CurveID
+	// HTTP2 is whether HTTP/2 is negotiated
+	HTTP2 bool
+}
+
+// ParseTLSPolicy parses a TLS policy and rejects insecure or contradictory combinations
+func ParseTLSPolicy(minVersion, maxVersion, cipherSuites, curves string, http2 bool) (*TLSPolicy, error) {
+	policy := &TLSPolicy{HTTP2: http2}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q, must be 1.2 or 1.3", minVersion)
+	}
+	policy.MinVersion = version
+
+	if maxVersion != "" {
+		version, ok := tlsVersions[maxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported maximum TLS version %q, must be 1.2 or 1.3", maxVersion)
+		}
+		if version < policy.MinVersion {
+			return nil, fmt.Errorf("maximum TLS version %s is below minimum TLS version %s", maxVersion, minVersion)
+		}
+		policy.MaxVersion = version
+	}
+
+	for _, name := range parseList(cipherSuites) {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		policy.CipherSuites = append(policy.CipherSuites, id)
+	}
+	if len(policy.CipherSuites) > 0 {
+		// TLS 1.3 cipher suites are not configurable
+		if policy.MinVersion == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suites cannot be configured when the minimum TLS version is 1.3")
+		}
+		if policy.HTTP2 && !containsAny(policy.CipherSuites, http2RequiredCiphers) {
+			return nil, fmt.Errorf("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 among the cipher suites")
+		}
+	}
+
+	for _, name := range parseList(curves) {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", name)
+		}
+		policy.CurvePreferences = append(policy.CurvePreferences, id)
+	}
+
+	return policy, nil
+}
+
+// Apply configures the TLS config and HTTP server according to the policy
+func (p *TLSPolicy) Apply(config *tls.Config, server *http.Server) {
+	config.MinVersion = p.MinVersion
+	config.MaxVersion = p.MaxVersion
+	config.CipherSuites = p.CipherSuites
+	config.CurvePreferences = p.CurvePreferences
+
+	if !p.HTTP2 {
+		// A non-nil empty map disables the automatic HTTP/2 upgrade
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+}
+
+// cipherSuiteID returns the ID of a secure cipher suite by name
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name != name {
+			continue
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return 0, fmt.Errorf("cipher suite %s is a TLS 1.3 cipher suite, which is not configurable", name)
+		}
+		return suite.ID, nil
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unsupported cipher suite %q", strings.TrimSpace(name))
+}
+
+// containsAny reports whether ids contains any of wanted
+func containsAny(ids, wanted []uint16) bool {
+	for _, id := range ids {
+		for _, w := range wanted {
+			if id == w {
+				return true
+			}
+		}
+	}
+	return false
+}
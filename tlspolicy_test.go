@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestParseTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		minVersion   string
+		maxVersion   string
+		cipherSuites string
+		curves       string
+		http2        bool
+		wantErr      bool
+		want         TLSPolicy
+	}{
+		{name: "defaults", minVersion: "1.2", http2: true, want: TLSPolicy{MinVersion: tls.VersionTLS12, HTTP2: true}},
+		{name: "TLS 1.3 only", minVersion: "1.3", maxVersion: "1.3", want: TLSPolicy{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13}},
+		{
+			name:         "cipher suites and curves",
+			minVersion:   "1.2",
+			cipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			curves:       "X25519,P256",
+			http2:        true,
+			want: TLSPolicy{
+				MinVersion:       tls.VersionTLS12,
+				CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+				HTTP2:            true,
+			},
+		},
+		{
+			name:         "HTTP/2 disabled without its cipher suites",
+			minVersion:   "1.2",
+			cipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			want:         TLSPolicy{MinVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}},
+		},
+		{name: "TLS 1.0", minVersion: "1.0", wantErr: true},
+		{name: "unknown maximum version", minVersion: "1.2", maxVersion: "1.4", wantErr: true},
+		{name: "maximum below minimum", minVersion: "1.3", maxVersion: "1.2", wantErr: true},
+		{name: "insecure cipher suite", minVersion: "1.2", cipherSuites: "TLS_RSA_WITH_RC4_128_SHA", wantErr: true},
+		{name: "CBC cipher suite", minVersion: "1.2", cipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", wantErr: true},
+		{name: "TLS 1.3 cipher suite", minVersion: "1.2", cipherSuites: "TLS_AES_128_GCM_SHA256", wantErr: true},
+		{name: "unknown cipher suite", minVersion: "1.2", cipherSuites: "TLS_NOPE", wantErr: true},
+		{name: "TLS 1.3 with explicit suites", minVersion: "1.3", cipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", wantErr: true},
+		{name: "HTTP/2 without its cipher suites", minVersion: "1.2", cipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", http2: true, wantErr: true},
+		{name: "unknown curve", minVersion: "1.2", curves: "P224", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseTLSPolicy(tt.minVersion, tt.maxVersion, tt.cipherSuites, tt.curves, tt.http2)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseTLSPolicy = %+v, want an error", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.MinVersion != tt.want.MinVersion || policy.MaxVersion != tt.want.MaxVersion || policy.HTTP2 != tt.want.HTTP2 ||
+				!slices.Equal(policy.CipherSuites, tt.want.CipherSuites) || !slices.Equal(policy.CurvePreferences, tt.want.CurvePreferences) {
+				t.Errorf("ParseTLSPolicy = %+v, want %+v", policy, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSPolicyApply(t *testing.T) {
+	for _, http2 := range []bool{true, false} {
+		policy, err := ParseTLSPolicy("1.2", "1.3", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "X25519", http2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &tls.Config{}
+		server := &http.Server{}
+		policy.Apply(config, server)
+		if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS13 ||
+			!slices.Equal(config.CipherSuites, policy.CipherSuites) || !slices.Equal(config.CurvePreferences, policy.CurvePreferences) {
+			t.Errorf("config = %+v, want the policy settings", config)
+		}
+		// Only a non-nil TLSNextProto disables HTTP/2
+		if disabled := server.TLSNextProto != nil; disabled == http2 {
+			t.Errorf("http2 = %v, TLSNextProto = %v", http2, server.TLSNextProto)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation scope of the admission spans
+const TracerName = "nodelocaldns-admission-controller"
+
+// Span names of the admission handling stages
+const (
+	SpanAdmission     = "admission"
+	SpanDecode        = "decode"
+	SpanResolvePolicy = "resolve-policy"
+	SpanInject        = "inject"
+	SpanGeneratePatch = "generate-patch"
+	SpanEncode        = "encode"
+)
+
+// Span attributes describing the admission decision
+const (
+	attributeOperation  = "nodelocaldns.operation"
+	attributeNamespace  = "nodelocaldns.namespace"
+	attributeDecision   = "nodelocaldns.decision"
+	attributeReason     = "nodelocaldns.reason"
+	attributeProfile    = "nodelocaldns.profile"
+	attributePatchBytes = "nodelocaldns.patch_bytes"
+)
+
+// tracePropagator extracts the W3C trace context and baggage of incoming requests
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// serviceResource describes the webhook to OpenTelemetry backends
+func serviceResource() *resource.Resource {
+	return resource.NewSchemaless(
+		attribute.String("service.name", "nodelocaldns-admission-controller"),
+		attribute.String("service.version", Version),
+	)
+}
+
+// NewTracerProvider creates a tracer provider exporting spans in batches over OTLP/HTTP,
+// sampling the given ratio of the traces not already sampled by the caller
+func NewTracerProvider(ctx context.Context, endpoint string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be between 0 and 1, got %v", sampleRatio)
+	}
+
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(serviceResource()),
+	), nil
+}
+
+// endSpan ends a span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
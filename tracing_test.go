@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	s := newTestServer()
+	s.tracer = provider.Tracer(TracerName)
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodPost, InjectPath, bytes.NewReader([]byte(testPodReview)))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	rec := httptest.NewRecorder()
+	s.HandleInject(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	root, ok := byName[SpanAdmission]
+	if !ok {
+		t.Fatalf("no %s span in %d spans", SpanAdmission, len(spans))
+	}
+	if got := root.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace ID = %s, want the incoming %s", got, traceID)
+	}
+	if got := root.Parent.SpanID().String(); got != parentID || !root.Parent.IsRemote() {
+		t.Errorf("parent = %s, want the remote %s", got, parentID)
+	}
+
+	for _, name := range []string{SpanDecode, SpanResolvePolicy, SpanInject, SpanGeneratePatch, SpanEncode} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the %s span", name, SpanAdmission)
+		}
+	}
+
+	attributes := attribute.NewSet(root.Attributes...)
+	for key, want := range map[string]string{attributeDecision: DecisionInjected, attributeReason: ReasonInjected, attributeProfile: DefaultProfile} {
+		if got, _ := attributes.Value(attribute.Key(key)); got.AsString() != want {
+			t.Errorf("%s = %q, want %q", key, got.AsString(), want)
+		}
+	}
+}
+
+func TestTracingUnknownProfile(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	s := newTestServer()
+	s.tracer = provider.Tracer(TracerName)
+
+	_, response, _ := admitPodIn(t, s, "shop", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web",
+		Annotations: map[string]string{ProfileAnnotation: "missing"},
+	}})
+	if response.Allowed {
+		t.Fatal("pod selecting an unknown profile was allowed")
+	}
+
+	// The denial fails the policy resolution span
+	for _, span := range exporter.GetSpans() {
+		if span.Name == SpanResolvePolicy {
+			if span.Status.Code != codes.Error {
+				t.Errorf("%s status = %v, want %v", SpanResolvePolicy, span.Status.Code, codes.Error)
+			}
+			return
+		}
+	}
+	t.Errorf("no %s span", SpanResolvePolicy)
+}
@@ -0,0 +1,4 @@
+package main
+
+// Version is the webhook version, set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"